@@ -0,0 +1,100 @@
+package main
+
+import (
+	"github.com/google/gopacket/layers"
+)
+
+// TCP option kinds for AccECN.
+const (
+	TCPOptionKindAccECN0 layers.TCPOptionKind = 172
+	TCPOptionKindAccECN1 layers.TCPOptionKind = 174
+)
+
+// Initial values of the AccECN counters.
+const (
+	accECNInitCEP = 5
+	accECNInitE0B = 1
+	accECNInitCEB = 0
+	accECNInitE1B = 1
+)
+
+// AccECNData holds the AccECN feedback sent in one direction, about the
+// segments received from the other direction. CEPackets is accumulated from
+// the 3-bit ACE field, so it undercounts if more than seven CE marked packets
+// arrive between acks. The byte counts are accumulated from the 24-bit fields
+// in the AccECN options.
+type AccECNData struct {
+	Acks       uint64
+	OptionAcks uint64
+	CEPackets  uint64
+	ECT0Bytes  uint64
+	CEBytes    uint64
+	ECT1Bytes  uint64
+	// handshakeAck is true if the next ack is the client's handshake ack,
+	// whose ACE field reflects the SYN-ACK's codepoint, not a counter
+	handshakeAck bool
+	ace          uint8
+	e0b          uint32
+	ceb          uint32
+	e1b          uint32
+}
+
+// NewAccECNData returns a new AccECNData, where client is true for the data
+// from the client side of the connection.
+func NewAccECNData(client bool) *AccECNData {
+	return &AccECNData{
+		handshakeAck: client,
+		ace:          accECNInitCEP,
+		e0b:          accECNInitE0B,
+		ceb:          accECNInitCEB,
+		e1b:          accECNInitE1B,
+	}
+}
+
+// Ack records the feedback in a non-SYN ack.
+func (a *AccECNData) Ack(tcp *layers.TCP) {
+	a.Acks++
+
+	ace := uint8(0)
+	if tcp.NS {
+		ace |= 4
+	}
+	if tcp.CWR {
+		ace |= 2
+	}
+	if tcp.ECE {
+		ace |= 1
+	}
+	if a.handshakeAck {
+		a.handshakeAck = false
+	} else {
+		a.CEPackets += uint64((ace - a.ace) & 0x07)
+		a.ace = ace
+	}
+
+	for _, opt := range tcp.Options {
+		var e0b, ceb, e1b *uint32
+		var e0bn, cebn, e1bn *uint64
+		switch opt.OptionType {
+		case TCPOptionKindAccECN0:
+			e0b, ceb, e1b = &a.e0b, &a.ceb, &a.e1b
+			e0bn, cebn, e1bn = &a.ECT0Bytes, &a.CEBytes, &a.ECT1Bytes
+		case TCPOptionKindAccECN1:
+			e0b, ceb, e1b = &a.e1b, &a.ceb, &a.e0b
+			e0bn, cebn, e1bn = &a.ECT1Bytes, &a.CEBytes, &a.ECT0Bytes
+		default:
+			continue
+		}
+		// fields are in the order given, and trailing fields may be omitted
+		last := []*uint32{e0b, ceb, e1b}
+		tot := []*uint64{e0bn, cebn, e1bn}
+		for i := 0; i < 3 && len(opt.OptionData) >= 3*(i+1); i++ {
+			b := opt.OptionData[3*i:]
+			v := uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+			*tot[i] += uint64((v - *last[i]) & 0xffffff)
+			*last[i] = v
+		}
+		a.OptionAcks++
+		break
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// aceAck returns an ack with the given ACE field value.
+func aceAck(ace uint8) *layers.TCP {
+	return &layers.TCP{NS: ace&4 != 0, CWR: ace&2 != 0, ECE: ace&1 != 0}
+}
+
+func TestAccECNCEPackets(t *testing.T) {
+	tests := []struct {
+		name   string
+		client bool
+		aces   []uint8
+		want   uint64
+	}{
+		{"unchanged", false, []uint8{5, 5}, 0},
+		{"increment", false, []uint8{6, 7}, 2},
+		{"wraparound", false, []uint8{7, 0, 1}, 4},
+		{"wraparound by seven", false, []uint8{4}, 7},
+		{"full cycle", false, []uint8{1, 5}, 8},
+		{"handshake ack", true, []uint8{2, 6}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAccECNData(tt.client)
+			for _, ace := range tt.aces {
+				a.Ack(aceAck(ace))
+			}
+			if a.CEPackets != tt.want {
+				t.Errorf("CEPackets = %d, want %d", a.CEPackets, tt.want)
+			}
+			if a.Acks != uint64(len(tt.aces)) {
+				t.Errorf("Acks = %d, want %d", a.Acks, len(tt.aces))
+			}
+		})
+	}
+}
+
+func TestAccECNBytes(t *testing.T) {
+	opt := func(kind layers.TCPOptionKind, v ...uint32) layers.TCPOption {
+		var b []byte
+		for _, x := range v {
+			b = append(b, byte(x>>16), byte(x>>8), byte(x))
+		}
+		return layers.TCPOption{OptionType: kind, OptionData: b}
+	}
+	tests := []struct {
+		name           string
+		opts           []layers.TCPOption
+		ect0, ce, ect1 uint64
+		optionAcks     uint64
+	}{
+		{"AccECN0", []layers.TCPOption{opt(TCPOptionKindAccECN0, 11, 20, 31)},
+			10, 20, 30, 1},
+		{"AccECN1", []layers.TCPOption{opt(TCPOptionKindAccECN1, 31, 20, 11)},
+			10, 20, 30, 1},
+		{"omitted fields", []layers.TCPOption{opt(TCPOptionKindAccECN0, 11)},
+			10, 0, 0, 1},
+		{"wraparound", []layers.TCPOption{
+			opt(TCPOptionKindAccECN0, 0xffffff),
+			opt(TCPOptionKindAccECN0, 0x000001),
+		}, 0x1000000, 0, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAccECNData(false)
+			for _, o := range tt.opts {
+				tcp := aceAck(accECNInitCEP)
+				tcp.Options = []layers.TCPOption{o}
+				a.Ack(tcp)
+			}
+			if a.ECT0Bytes != tt.ect0 || a.CEBytes != tt.ce ||
+				a.ECT1Bytes != tt.ect1 {
+				t.Errorf("bytes = %d/%d/%d, want %d/%d/%d", a.ECT0Bytes,
+					a.CEBytes, a.ECT1Bytes, tt.ect0, tt.ce, tt.ect1)
+			}
+			if a.OptionAcks != tt.optionAcks {
+				t.Errorf("OptionAcks = %d, want %d", a.OptionAcks, tt.optionAcks)
+			}
+		})
+	}
+}
+
+func TestCaptureECNBytes(t *testing.T) {
+	codepoints := []ECN{ECT0, SCE, CE}
+	ms := time.Millisecond
+	// AccECN, where the server's ACE field counts the CE marked segment
+	accECN := []testSegment{
+		{at: 0, up: true, flags: "SECN", seq: 1000},
+		{at: 10 * ms, flags: "SAC", seq: 5000, ack: 1001},
+		{at: 20 * ms, up: true, flags: "A", seq: 1001, ack: 5001},
+		{at: 21 * ms, up: true, flags: "PAEN", seq: 1001, ack: 5001, len: 100,
+			ecn: ECT0},
+		{at: 22 * ms, up: true, flags: "PAEN", seq: 1101, ack: 5001, len: 100,
+			ecn: ECT0},
+		{at: 23 * ms, up: true, flags: "PAEN", seq: 1201, ack: 5001, len: 100,
+			ecn: CE},
+		{at: 31 * ms, flags: "AEN", seq: 5001, ack: 1201},
+		{at: 33 * ms, flags: "ACN", seq: 5001, ack: 1301},
+		{at: 40 * ms, up: true, flags: "FAEN", seq: 1301, ack: 5001},
+		{at: 50 * ms, flags: "FACN", seq: 5001, ack: 1302},
+		{at: 60 * ms, up: true, flags: "AEN", seq: 1302, ack: 5002},
+	}
+	tests := []struct {
+		name      string
+		segs      []testSegment
+		ecnBytes  ECNCounters
+		dataECN   ECNCounters
+		accECN    bool
+		cePackets uint64
+	}{
+		{"classic", testECNTransfer(func(i int) ECN { return codepoints[i] }),
+			ECNCounters{ECT0: 100, SCE: 100, CE: 100},
+			ECNCounters{ECT0: 1, SCE: 1, CE: 1}, false, 0},
+		{"AccECN", accECN, ECNCounters{ECT0: 200, CE: 100},
+			ECNCounters{ECT0: 2, CE: 1}, true, 1},
+	}
+	for _, v := range testIPVersions {
+		for _, tt := range tests {
+			t.Run(v.name+"/"+tt.name, func(t *testing.T) {
+				f := testFlow(t, testCapture(t, tt.segs, v.ip6, testConfig()))
+				if f.Up.ECNBytes != tt.ecnBytes {
+					t.Errorf("Up.ECNBytes = %+v, want %+v", f.Up.ECNBytes,
+						tt.ecnBytes)
+				}
+				if f.Up.DataECN != tt.dataECN {
+					t.Errorf("Up.DataECN = %+v, want %+v", f.Up.DataECN,
+						tt.dataECN)
+				}
+				if f.Down.ECNBytes.Total() != 0 || f.Down.DataECN.Total() != 0 {
+					t.Errorf("Down.ECNBytes = %+v, Down.DataECN = %+v, want 0",
+						f.Down.ECNBytes, f.Down.DataECN)
+				}
+				if f.AccECNAccepted != tt.accECN {
+					t.Fatalf("AccECNAccepted = %t, want %t", f.AccECNAccepted,
+						tt.accECN)
+				}
+				if tt.accECN && (f.Down.AccECN.CEPackets != tt.cePackets ||
+					f.Up.AccECN.CEPackets != 0) {
+					t.Errorf("AccECN CEPackets %d up, %d down, want 0, %d",
+						f.Up.AccECN.CEPackets, f.Down.AccECN.CEPackets,
+						tt.cePackets)
+				}
+			})
+		}
+	}
+}
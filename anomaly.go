@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ECNTracking records the ECN codepoints and DSCPs sent in one direction, to
+// detect ECN bleaching and codepoint or DSCP mangling along the path.
+type ECNTracking struct {
+	// BaseECN is the codepoint of the first data segment that isn't CE
+	BaseECN string `json:",omitempty"`
+	// NotECTAfterNegotiation counts non-retransmitted data segments that are
+	// Not-ECT after ECN was negotiated
+	NotECTAfterNegotiation uint64
+	// Unexpected counts data segments whose codepoint is neither BaseECN nor
+	// an expected mark (CE, or in SCE mode, ECT(1) on an ECT(0) flow)
+	Unexpected ECNCounters
+	// DSCPs are the distinct DSCP values seen, in order of appearance, as
+	// ints so they marshal as a JSON array and not a base64 string
+	DSCPs []int
+	// DSCPChanges counts packets whose DSCP differs from the first packet's
+	DSCPChanges uint64
+	baseECN     ECN
+	baseSet     bool
+}
+
+// Packet records the DSCP for a packet.
+func (t *ECNTracking) Packet(dscp uint8) {
+	d := int(dscp)
+	if len(t.DSCPs) > 0 && d != t.DSCPs[0] {
+		t.DSCPChanges++
+	}
+	for _, x := range t.DSCPs {
+		if x == d {
+			return
+		}
+	}
+	t.DSCPs = append(t.DSCPs, d)
+}
+
+// DataSegment records the codepoint for a data segment, where retransmitted
+// is true for retransmissions, which may legitimately be Not-ECT.
+func (t *ECNTracking) DataSegment(ecn ECN, retransmitted, negotiated bool,
+	mode ECT1Mode) {
+	if ecn == NotECT && negotiated && !retransmitted {
+		t.NotECTAfterNegotiation++
+	}
+	if !t.baseSet {
+		if ecn != CE {
+			t.baseECN = ecn
+			t.BaseECN = mode.Name(ecn)
+			t.baseSet = true
+		}
+		return
+	}
+	switch {
+	case ecn == t.baseECN, ecn == CE:
+	case ecn == SCE && t.baseECN == ECT0 && mode == ModeSCE:
+	case ecn == NotECT && retransmitted:
+	default:
+		t.Unexpected.Add(ecn)
+	}
+}
+
+// Anomalies returns descriptions of any anomalies detected.
+func (t *ECNTracking) Anomalies(mode ECT1Mode) (a []string) {
+	if t.NotECTAfterNegotiation > 0 {
+		a = append(a, fmt.Sprintf(
+			"%d data segments Not-ECT after ECN negotiated (bleached?)",
+			t.NotECTAfterNegotiation))
+	}
+	u := []struct {
+		ecn ECN
+		n   uint64
+	}{
+		{NotECT, t.Unexpected.NotECT},
+		{ECT0, t.Unexpected.ECT0},
+		{SCE, t.Unexpected.SCE},
+	}
+	for _, x := range u {
+		if x.n > 0 && !(x.ecn == NotECT && t.NotECTAfterNegotiation > 0) {
+			a = append(a, fmt.Sprintf("%d data segments %s from %s sender",
+				x.n, mode.Name(x.ecn), t.BaseECN))
+		}
+	}
+	if t.DSCPChanges > 0 {
+		s := make([]string, len(t.DSCPs))
+		for i, d := range t.DSCPs {
+			s[i] = fmt.Sprint(d)
+		}
+		a = append(a, fmt.Sprintf("DSCP changed on %d packets (seen: %s)",
+			t.DSCPChanges, strings.Join(s, ", ")))
+	}
+	return
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestECNTrackingDSCPsJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		dscps []uint8
+		json  string
+	}{
+		{"none", nil, `"DSCPs":null`},
+		{"one", []uint8{0, 0}, `"DSCPs":[0]`},
+		{"changed", []uint8{10, 0, 10, 46}, `"DSCPs":[10,0,46]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e ECNTracking
+			for _, d := range tt.dscps {
+				e.Packet(d)
+			}
+			b, err := json.Marshal(&e)
+			if err != nil {
+				t.Fatalf("unable to marshal (%s)", err)
+			}
+			if !strings.Contains(string(b), tt.json) {
+				t.Errorf("JSON %s does not contain %s", b, tt.json)
+			}
+			var r ECNTracking
+			if err := json.Unmarshal(b, &r); err != nil {
+				t.Fatalf("unable to unmarshal (%s)", err)
+			}
+			if !reflect.DeepEqual(r.DSCPs, e.DSCPs) ||
+				r.DSCPChanges != e.DSCPChanges {
+				t.Errorf("round trip DSCPs %v (%d changes), want %v (%d changes)",
+					r.DSCPs, r.DSCPChanges, e.DSCPs, e.DSCPChanges)
+			}
+		})
+	}
+}
+
+func TestCaptureECNAnomalies(t *testing.T) {
+	tests := []struct {
+		name      string
+		segs      []testSegment
+		anomalies []string
+	}{
+		{"clean", testECNTransfer(func(int) ECN { return ECT0 }), nil},
+		{"bleached", testECNTransfer(func(int) ECN { return NotECT }),
+			[]string{"3 data segments Not-ECT after ECN negotiated (bleached?)"}},
+		{"mangled", testECNTransfer(func(i int) ECN {
+			if i == 2 {
+				return ECT0
+			}
+			return SCE
+		}), []string{"1 data segments ECT0 from SCE sender"}},
+	}
+	for _, v := range testIPVersions {
+		for _, tt := range tests {
+			t.Run(v.name+"/"+tt.name, func(t *testing.T) {
+				f := testFlow(t, testCapture(t, tt.segs, v.ip6, testConfig()))
+				if a := f.Up.ECNTracking.Anomalies(ModeSCE); !reflect.DeepEqual(a,
+					tt.anomalies) {
+					t.Errorf("Up anomalies %q, want %q", a, tt.anomalies)
+				}
+				if a := f.Down.ECNTracking.Anomalies(ModeSCE); a != nil {
+					t.Errorf("Down anomalies %q, want none", a)
+				}
+			})
+		}
+	}
+}
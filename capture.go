@@ -2,8 +2,13 @@ package main
 
 import (
 	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"math"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/google/gopacket"
@@ -19,33 +24,167 @@ const (
 	CE     ECN = 0x03
 )
 
-func Capture(pch <-chan gopacket.Packet, d *Data) {
+func (e ECN) String() string {
+	switch e {
+	case NotECT:
+		return "NotECT"
+	case SCE:
+		return "SCE"
+	case ECT0:
+		return "ECT0"
+	case CE:
+		return "CE"
+	}
+	return "unknown"
+}
+
+// ECT1Mode selects how the ECT(1) codepoint is interpreted.
+type ECT1Mode int
+
+const (
+	// ModeSCE interprets ECT(1) as SCE.
+	ModeSCE ECT1Mode = iota
+	// ModeL4S interprets ECT(1) as the L4S identifier, and classifies flows as
+	// L4S or classic.
+	ModeL4S
+)
+
+// ParseECT1Mode returns the ECT1Mode for the string s.
+func ParseECT1Mode(s string) (m ECT1Mode, err error) {
+	switch s {
+	case "sce":
+		m = ModeSCE
+	case "l4s":
+		m = ModeL4S
+	default:
+		err = fmt.Errorf("unknown ECT(1) mode \"%s\"", s)
+	}
+	return
+}
+
+func (m ECT1Mode) String() string {
+	if m == ModeL4S {
+		return "L4S"
+	}
+	return "SCE"
+}
+
+func (m ECT1Mode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// Name returns the name of the ECN codepoint e in mode m.
+func (m ECT1Mode) Name(e ECN) string {
+	if m == ModeL4S && e == SCE {
+		return "ECT1"
+	}
+	return e.String()
+}
+
+// FieldName returns the name of a result field in mode m, where in L4S mode
+// fields starting with SCE start with ECT1 instead, and fields starting with
+// ESCE start with NS, as the NS bit doesn't echo SCE in L4S.
+func (m ECT1Mode) FieldName(name string) string {
+	if m == ModeL4S {
+		switch {
+		case strings.HasPrefix(name, "SCE"):
+			return "ECT1" + name[3:]
+		case strings.HasPrefix(name, "ESCE"):
+			return "NS" + name[4:]
+		}
+	}
+	return name
+}
+
+// ParseConfig contains the settings that control how packets are parsed.
+type ParseConfig struct {
+	ECT1Mode   ECT1Mode
+	FirstLayer gopacket.LayerType
+	SplitVLANs bool
+	Window     time.Duration
+	Events     io.Writer
+	// MaxRTTAge is the maximum age of unacked seqnos and unechoed TSVals
+	MaxRTTAge time.Duration
+	// BaseRTTWindow is the window for the minimum RTT used as the base RTT
+	BaseRTTWindow time.Duration
+}
+
+func Capture(pch <-chan gopacket.Packet, d *Data, c *ParseConfig) {
 	var eth layers.Ethernet
-	var ip4 layers.IPv4
-	var ip6 layers.IPv6
+	var sll layers.LinuxSLL
+	var sll2 LinuxSLL2
+	var lo layers.Loopback
+	var raw RawIP
+	var dot1q Dot1QStack
+	var ips IPStack
+	var ip4 = IPv4Layer{Stack: &ips}
+	var ip6 = IPv6Layer{Stack: &ips}
+	var gre layers.GRE
+	var vxlan layers.VXLAN
+	var geneve GeneveLayer
+	var mpls MPLSLayer
 	var tcp layers.TCP
+	var udp layers.UDP
+	var icmp4 layers.ICMPv4
+	var icmp6 layers.ICMPv6
 	var to *TCPOneWayData
 	var tor *TCPOneWayData
+	var w *TCPWindowData
+	var wr *TCPWindowData
 	var tk4 TCP4FlowKey
 	var tk6 TCP6FlowKey
+	var uk UDPFlowKey
 	var lastErr error
 	var lastErrCount int
+	var truncWarned bool
 	var flowIndex int
+	var ev TCPEvent
+	var enc *json.Encoder
 
-	parser := gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet)
+	if c.Events != nil {
+		enc = json.NewEncoder(c.Events)
+	}
+
+	parser := gopacket.NewDecodingLayerParser(c.FirstLayer)
 	parser.DecodingLayerParserOptions.IgnoreUnsupported = true
 	parser.SetDecodingLayerContainer(gopacket.DecodingLayerSparse(nil))
 	parser.AddDecodingLayer(&eth)
+	parser.AddDecodingLayer(&sll)
+	parser.AddDecodingLayer(&sll2)
+	parser.AddDecodingLayer(&lo)
+	parser.AddDecodingLayer(&raw)
+	parser.AddDecodingLayer(&dot1q)
 	parser.AddDecodingLayer(&ip4)
 	parser.AddDecodingLayer(&ip6)
+	parser.AddDecodingLayer(&gre)
+	parser.AddDecodingLayer(&vxlan)
+	parser.AddDecodingLayer(&geneve)
+	parser.AddDecodingLayer(&mpls)
 	parser.AddDecodingLayer(&tcp)
+	parser.AddDecodingLayer(&udp)
+	parser.AddDecodingLayer(&icmp4)
+	parser.AddDecodingLayer(&icmp6)
 	dec := []gopacket.LayerType{}
 
 	d.Meta.ParseStartTime = time.Now()
+	d.Meta.ECT1Mode = c.ECT1Mode
 
 	for p := range pch {
 		// decode packet
+		dot1q.Reset()
+		ips.Reset()
 		if err := parser.DecodeLayers(p.Data(), &dec); err != nil {
+			// warn once if the snaplen truncates transport headers, which
+			// is likely for encapsulated packets with the default snaplen
+			if ci := p.Metadata().CaptureInfo; !truncWarned &&
+				ci.CaptureLength < ci.Length && len(dec) > 0 &&
+				(dec[len(dec)-1] == layers.LayerTypeIPv4 ||
+					dec[len(dec)-1] == layers.LayerTypeIPv6) {
+				log.Printf("warning: transport header truncated by snaplen "+
+					"(%d of %d bytes captured), packets will be skipped, "+
+					"increase snaplen with -s", ci.CaptureLength, ci.Length)
+				truncWarned = true
+			}
 			if lastErr != nil && err.Error() == lastErr.Error() {
 				lastErrCount++
 			} else {
@@ -62,20 +201,33 @@ func Capture(pch <-chan gopacket.Packet, d *Data) {
 			lastErr = nil
 		}
 
+		// go to next packet if not IP
+		if len(ips.Headers) == 0 {
+			continue
+		}
+
 		// lock data while updating
 		d.Lock()
 
-		// identify parsed layers
-		isTCP := false
+		// identify parsed layers, where the innermost IP and transport layers
+		// are used for tunneled packets
+		var transport gopacket.LayerType
 		isIP4 := true
 		for _, lt := range dec {
 			switch lt {
-			case layers.LayerTypeTCP:
-				isTCP = true
+			case layers.LayerTypeTCP, layers.LayerTypeUDP,
+				layers.LayerTypeICMPv4, layers.LayerTypeICMPv6:
+				transport = lt
+			case layers.LayerTypeIPv4:
+				isIP4 = true
 			case layers.LayerTypeIPv6:
 				isIP4 = false
 			}
 		}
+		isTCP := transport == layers.LayerTypeTCP
+		isUDP := transport == layers.LayerTypeUDP
+		isICMP4 := transport == layers.LayerTypeICMPv4
+		isICMP6 := transport == layers.LayerTypeICMPv6
 
 		// get timestamp and update capture times
 		tstamp := p.Metadata().Timestamp
@@ -84,23 +236,67 @@ func Capture(pch <-chan gopacket.Packet, d *Data) {
 		}
 		d.Meta.CaptureEndTime = tstamp
 
-		// update IP stats
+		// update IP stats, using the outer length for tunneled packets
 		d.IP.Packets++
-		var ipLen uint
+		d.IP.Bytes += uint64(ips.Outer().Length)
+		ipLen := ips.Inner().Length
+
+		// get dscp according to IP version
+		var dscp uint8
 		if isIP4 {
-			ipLen = uint(ip4.Length)
+			dscp = ip4.TOS
 		} else {
-			ipLen = uint(ip6.Length) + 40
+			dscp = ip6.TrafficClass
+		}
+
+		// get VLAN stack key for flow identification, if enabled
+		var vlan uint32
+		if c.SplitVLANs {
+			vlan = dot1q.Key()
+		}
+
+		// record QUIC or IRTT packets
+		if isUDP {
+			var srcIP, dstIP net.IP
+			if isIP4 {
+				srcIP, dstIP = ip4.SrcIP, ip4.DstIP
+			} else {
+				srcIP, dstIP = ip6.SrcIP, ip6.DstIP
+			}
+			copy(uk.SrcIP[:], srcIP.To16())
+			uk.SrcPort = udp.SrcPort
+			copy(uk.DstIP[:], dstIP.To16())
+			uk.DstPort = udp.DstPort
+			uk.VLAN = vlan
+			if !d.CaptureQUIC(uk, srcIP, dstIP, udp.LayerPayload(), ECN(dscp&0x03),
+				tstamp, &flowIndex) {
+				d.CaptureIRTT(uk, srcIP, dstIP, udp.LayerPayload(), ECN(dscp&0x03),
+					tstamp, &flowIndex)
+			}
+			d.Unlock()
+			continue
+		}
+
+		// record ICMP packets
+		if isICMP4 {
+			d.CaptureICMPv4(&ip4.IPv4, &icmp4, ECN(dscp&0x03), vlan, tstamp, &flowIndex)
+			d.Unlock()
+			continue
+		}
+		if isICMP6 {
+			d.CaptureICMPv6(&ip6.IPv6, &icmp6, ECN(dscp&0x03), vlan, tstamp, &flowIndex)
+			d.Unlock()
+			continue
 		}
-		d.IP.Bytes += uint64(ipLen)
 
-		// go to next packet if not TCP or it's a TCP reset
-		if !isTCP || tcp.RST {
+		// go to next packet if not TCP
+		if !isTCP {
 			d.Unlock()
 			continue
 		}
 
-		// get addresses and ports for flow identification
+		// get addresses and ports for flow identification, and start a new
+		// flow if the 5-tuple is reused for a new connection
 		var ok, rok bool
 		var f *TCPFlowData
 		up := true
@@ -109,46 +305,65 @@ func Capture(pch <-chan gopacket.Packet, d *Data) {
 			tk4.SrcPort = tcp.SrcPort
 			copy(tk4.DstIP[:], ip4.DstIP)
 			tk4.DstPort = tcp.DstPort
+			tk4.VLAN = vlan
 			if f, ok = d.TCP4[tk4]; !ok {
-				if f, rok = d.TCP4[tk4.Reverse()]; !rok {
-					f = &TCPFlowData{
-						Index:   flowIndex,
-						SrcIP:   ip4.SrcIP,
-						DstIP:   ip4.DstIP,
-						SrcPort: tcp.SrcPort,
-						DstPort: tcp.DstPort,
-						Up:      NewTCPOneWayData(),
-						Down:    NewTCPOneWayData(),
-					}
-					d.TCP4[tk4] = f
-					flowIndex++
-				} else {
+				if f, rok = d.TCP4[tk4.Reverse()]; rok {
 					up = false
 				}
 			}
+			if f != nil && f.Reused(&tcp, up) {
+				d.TCPClosed = append(d.TCPClosed, f)
+				if ok {
+					delete(d.TCP4, tk4)
+				} else {
+					delete(d.TCP4, tk4.Reverse())
+				}
+				f, up = nil, true
+			}
+			if f == nil && !tcp.RST {
+				f = NewTCPFlowData(flowIndex, ip4.SrcIP, ip4.DstIP,
+					tcp.SrcPort, tcp.DstPort, tstamp)
+				f.VLANs = vlanIDs(dot1q.IDs)
+				f.Tunnel = tunnelNames(dec)
+				d.TCP4[tk4] = f
+				flowIndex++
+			}
 		} else {
 			copy(tk6.SrcIP[:], ip6.SrcIP)
 			tk6.SrcPort = tcp.SrcPort
 			copy(tk6.DstIP[:], ip6.DstIP)
 			tk6.DstPort = tcp.DstPort
+			tk6.VLAN = vlan
 			if f, ok = d.TCP6[tk6]; !ok {
-				if f, rok = d.TCP6[tk6.Reverse()]; !rok {
-					f = &TCPFlowData{
-						Index:   flowIndex,
-						SrcIP:   ip6.SrcIP,
-						DstIP:   ip6.DstIP,
-						SrcPort: tcp.SrcPort,
-						DstPort: tcp.DstPort,
-						Up:      NewTCPOneWayData(),
-						Down:    NewTCPOneWayData(),
-					}
-					d.TCP6[tk6] = f
-					flowIndex++
-				} else {
+				if f, rok = d.TCP6[tk6.Reverse()]; rok {
 					up = false
 				}
 			}
+			if f != nil && f.Reused(&tcp, up) {
+				d.TCPClosed = append(d.TCPClosed, f)
+				if ok {
+					delete(d.TCP6, tk6)
+				} else {
+					delete(d.TCP6, tk6.Reverse())
+				}
+				f, up = nil, true
+			}
+			if f == nil && !tcp.RST {
+				f = NewTCPFlowData(flowIndex, ip6.SrcIP, ip6.DstIP,
+					tcp.SrcPort, tcp.DstPort, tstamp)
+				f.VLANs = vlanIDs(dot1q.IDs)
+				f.Tunnel = tunnelNames(dec)
+				d.TCP6[tk6] = f
+				flowIndex++
+			}
+		}
+
+		// skip resets for unknown flows
+		if f == nil {
+			d.Unlock()
+			continue
 		}
+		f.LastTime = tstamp
 
 		// set one-way stats pointers based on direction
 		if up {
@@ -159,6 +374,51 @@ func Capture(pch <-chan gopacket.Packet, d *Data) {
 			tor = f.Up
 		}
 
+		// get segment length according to IP version, where the IPv6 length
+		// includes the fixed header
+		var segLen uint32
+		if isIP4 {
+			segLen = uint32(ipLen) - 4*uint32(ip4.IHL) - 4*uint32(tcp.DataOffset)
+		} else {
+			segLen = uint32(ipLen) - 40 - 4*uint32(tcp.DataOffset)
+		}
+
+		// start event for segment
+		ev = TCPEvent{
+			Time:      tstamp,
+			Index:     f.Index,
+			Direction: "Up",
+			Seq:       tcp.Seq,
+			Ack:       tcp.Ack,
+			SegLen:    segLen,
+			ECN:       c.ECT1Mode.Name(ECN(dscp & 0x03)),
+			NS:        tcp.NS,
+			ECE:       tcp.ECE,
+			CWR:       tcp.CWR,
+			RST:       tcp.RST,
+		}
+		if !up {
+			ev.Direction = "Down"
+		}
+		if ips.Tunneled() {
+			ev.OuterECN = c.ECT1Mode.Name(ips.Outer().ECN)
+		}
+
+		// record reset, write its event and go to next packet
+		if tcp.RST {
+			f.Reset(ev.Direction)
+			enc = writeEvent(enc, &ev)
+			d.Unlock()
+			continue
+		}
+
+		// get windows for both directions, if enabled
+		if c.Window > 0 {
+			wi := int(tstamp.Sub(d.Meta.CaptureStartTime) / c.Window)
+			w = to.Window(wi, c.Window)
+			wr = tor.Window(wi, c.Window)
+		}
+
 		// read timestamps
 		var tsval, tsecr uint32
 		for _, opt := range tcp.Options {
@@ -166,10 +426,23 @@ func Capture(pch <-chan gopacket.Packet, d *Data) {
 				opt.OptionLength == 10 {
 				tsval = binary.BigEndian.Uint32(opt.OptionData[:4])
 				tsecr = binary.BigEndian.Uint32(opt.OptionData[4:])
-				to.TSValTimes[tsval] = tstamp
-				if pt, ok := tor.TSValTimes[tsecr]; ok {
-					tor.TSValRTT.Push(tstamp.Sub(pt))
-					delete(tor.TSValTimes, tsecr)
+				ev.TSVal = tsval
+				ev.TSEcr = tsecr
+				to.TSValTimes.Expire(tstamp, c.MaxRTTAge)
+				to.TSValTimes.Put(tsval, tstamp)
+				if pt, ok := tor.TSValTimes.Take(tsecr); ok {
+					rtt := tstamp.Sub(pt)
+					tor.TSValRTT.Push(rtt)
+					qd := rtt - tor.TSValBaseRTT.Push(tstamp, rtt, c.BaseRTTWindow)
+					tor.TSValQueueDelay.Push(qd)
+					ev.TSValRTTMillis = durToMs(rtt)
+					if wr != nil {
+						wr.TSValRTT.Push(rtt)
+						wr.TSValQueueDelay.Push(qd)
+					}
+				}
+				if tcp.ACK {
+					tor.TSValTimes.Evict(tsecr)
 				}
 				break
 			}
@@ -181,28 +454,47 @@ func Capture(pch <-chan gopacket.Packet, d *Data) {
 			if tcp.SYN {
 				if tcp.ACK {
 					f.ECNAccepted = tcp.ECE
+					// any SYN-ACK response to AccECN but 000 and 001 is AccECN
+					if f.AccECNInitiated && (tcp.NS || tcp.CWR) {
+						f.ECNAccepted = true
+						f.AccECNAccepted = true
+						to.AccECN = NewAccECNData(false)
+						tor.AccECN = NewAccECNData(true)
+					}
 				} else {
 					f.ECNInitiated = tcp.ECE && tcp.CWR
+					f.AccECNInitiated = tcp.ECE && tcp.CWR && tcp.NS
 				}
-				to.ExpSeq++
+				to.ExpSeq += 1 + segLen // SYN takes one seqno
+				to.ISN = tcp.Seq
+				to.SYNSeen = true
+				synOptions(to, &tcp)
 			}
 			to.HiTSVal = tsval
 			to.Initialized = true
 		}
+		f.Handshake(&tcp, to, tor, tstamp)
 
-		// get dscp and segment length according to IP version
-		var dscp uint8
-		var segLen uint32
-		if isIP4 {
-			segLen = uint32(ipLen) - 4*uint32(ip4.IHL) - 4*uint32(tcp.DataOffset)
-			dscp = ip4.TOS
-		} else {
-			segLen = uint32(ipLen) - 40
-			dscp = ip6.TrafficClass
-		}
 		if segLen > 0 {
-			to.SeqTimes[tcp.Seq] = tstamp
+			to.SeqTimes.Expire(tstamp, c.MaxRTTAge)
+			to.SeqTimes.Put(tcp.Seq, tstamp)
 			to.DataSegments++
+			if segLen > to.MaxSegLen {
+				to.MaxSegLen = segLen
+			}
+			ecn := ECN(dscp & 0x03)
+			to.DataECN.Add(ecn)
+			to.ECNBytes.AddN(ecn, uint64(segLen))
+			if ecn == CE && to.DataSegments > 1 && to.PriorDataECN == SCE {
+				to.ECT1ToCE++
+			}
+			to.PriorDataECN = ecn
+			if c.ECT1Mode == ModeSCE && to.AccECN == nil {
+				to.SCEFeedback.Segment(tcp.Seq+segLen, ecn == SCE, tstamp)
+			}
+			if ips.Tunneled() {
+				to.TunnelECN.Add(ips.Outer().ECN, ips.Inner().ECN)
+			}
 		}
 
 		// handle acks
@@ -226,6 +518,7 @@ func Capture(pch <-chan gopacket.Packet, d *Data) {
 						}
 					}
 					to.DuplicateAcks++
+					ev.DuplicateAck = true
 				} else { // standard ack
 					if to.SackedBytesCtr > 0 {
 						ackedBytes -= to.SackedBytesCtr
@@ -233,10 +526,21 @@ func Capture(pch <-chan gopacket.Packet, d *Data) {
 					}
 					to.AckedBytes += uint64(ackedBytes)
 					to.LastAckTime = tstamp
-					if pt, ok := tor.SeqTimes[to.PriorAck]; ok {
-						tor.SeqRTT.Push(tstamp.Sub(pt))
-						delete(tor.SeqTimes, to.PriorAck)
+					if c.ECT1Mode == ModeSCE && to.AccECN == nil {
+						tor.SCEFeedback.Ack(tcp.Ack, tcp.NS, tstamp)
+					}
+					if pt, ok := tor.SeqTimes.Take(to.PriorAck); ok {
+						rtt := tstamp.Sub(pt)
+						tor.SeqRTT.Push(rtt)
+						qd := rtt - tor.SeqBaseRTT.Push(tstamp, rtt, c.BaseRTTWindow)
+						tor.SeqQueueDelay.Push(qd)
+						ev.SeqRTTMillis = durToMs(rtt)
+						if wr != nil {
+							wr.SeqRTT.Push(rtt)
+							wr.SeqQueueDelay.Push(qd)
+						}
 					}
+					tor.SeqTimes.Evict(tcp.Ack)
 					// Note: if SACK is not supported, implementations count one
 					// segment of ESCE acked bytes, for what that's worth. Also
 					// in rare cases might encounter window probes.
@@ -251,36 +555,69 @@ func Capture(pch <-chan gopacket.Packet, d *Data) {
 			if !tcp.SYN && !tcp.FIN && !to.FinSeen {
 				// detect retransmitted and late (out-of-order) segments
 				seqDelta := tcp.Seq - to.ExpSeq
-				if seqDelta > math.MaxUint32/2 {
+				retransmitted := seqDelta > math.MaxUint32/2
+				if retransmitted {
 					to.RetransmittedSegments++
+					ev.Retransmitted = true
 				} else {
 					if seqDelta > 0 {
 						to.Gaps++
+						ev.Gap = true
 						to.GapBytes += uint64(seqDelta)
 					}
 					to.ExpSeq = tcp.Seq + segLen
 				}
 
+				// record codepoints for bleaching and mangling detection
+				if segLen > 0 {
+					to.ECNTracking.DataSegment(ECN(dscp&0x03), retransmitted,
+						f.ECNAccepted, c.ECT1Mode)
+				}
+
 				if tsval-to.HiTSVal > math.MaxUint32/2 {
 					to.LateSegments++
+					ev.Late = true
 				} else {
 					to.HiTSVal = tsval
 				}
 
-				// record congestion related stats
-				if tcp.CWR {
-					to.CWR++
-				}
-				if tcp.ECE {
-					to.ECE++
-				}
-				if tcp.NS {
-					to.ESCE++
-					to.ESCEAckedBytes += uint64(ackedBytes)
+				// record congestion related stats, where for AccECN the NS,
+				// CWR and ECE bits are the ACE field. For classic ECN, the
+				// reaction latency is measured from the first unechoed CE
+				// to the next ECE, and from the rising edge of ECE to CWR.
+				if to.AccECN != nil {
+					to.AccECN.Ack(&tcp)
+				} else {
+					if tcp.CWR {
+						to.CWR++
+						if !to.PendingECETime.IsZero() {
+							to.ECEToCWR.Push(tstamp.Sub(to.PendingECETime))
+							to.PendingECETime = time.Time{}
+						}
+					}
+					if tcp.ECE {
+						to.ECE++
+						if !tor.PendingCETime.IsZero() {
+							tor.CEToECE.Push(tstamp.Sub(tor.PendingCETime))
+							tor.PendingCETime = time.Time{}
+						}
+						if !to.PriorECE && tor.PendingECETime.IsZero() {
+							tor.PendingECETime = tstamp
+						}
+					}
+					to.PriorECE = tcp.ECE
+					if tcp.NS {
+						to.ESCE++
+						to.ESCEAckedBytes += uint64(ackedBytes)
+					}
 				}
 				ecn := ECN(dscp & 0x03)
 				if ecn == CE {
 					to.CE++
+					if segLen > 0 && to.AccECN == nil && f.ECNAccepted &&
+						to.PendingCETime.IsZero() {
+						to.PendingCETime = tstamp
+					}
 				}
 				if ecn == SCE {
 					to.SCE++
@@ -298,9 +635,58 @@ func Capture(pch <-chan gopacket.Packet, d *Data) {
 			to.Acks++
 		}
 
+		// advance the expected seqno past a FIN, which takes one seqno after
+		// any data it carries
+		if tcp.FIN && !to.FinSeen && tcp.Seq-to.ExpSeq <= math.MaxUint32/2 {
+			to.ExpSeq = tcp.Seq + segLen + 1
+		}
+
+		// record the advertised receive window, once both SYNs are seen so
+		// the window scale is known, and window probes
+		if tcp.ACK && !tcp.SYN && to.SYNSeen && tor.SYNSeen {
+			to.ReceiveWindowAdvertised(tcp.Window, tor.WindowScale)
+		}
+		if tor.RwndKnown && tor.Rwnd == 0 && !tcp.SYN && !tcp.FIN &&
+			(segLen == 1 || (segLen == 0 && tcp.Seq == tor.PriorAck-1)) {
+			to.WindowProbes++
+		}
+
+		// record bytes in flight for this direction after data segments, and
+		// for the reverse direction after acks, and receiver limited time
+		if segLen > 0 && tor.Acks > 0 {
+			if n, ok := to.Flight(tor.PriorAck); ok {
+				to.FlightBytes.Push(float64(n))
+				ev.FlightBytes = n
+				if w != nil {
+					w.FlightBytes.Push(float64(n))
+				}
+				if tor.RwndKnown {
+					to.ReceiverLimit(n, tor.Rwnd, tstamp)
+				}
+			}
+		}
+		if tcp.ACK && !ev.DuplicateAck && tor.DataSegments > 0 {
+			if n, ok := tor.Flight(to.PriorAck); ok {
+				tor.FlightBytes.Push(float64(n))
+				if wr != nil {
+					wr.FlightBytes.Push(float64(n))
+				}
+				if to.RwndKnown {
+					tor.ReceiverLimit(n, to.Rwnd, tstamp)
+				}
+			}
+		}
+
+		// record DSCP for mangling detection
+		to.ECNTracking.Packet(dscp >> 2)
+
 		// record inter-packet gap stats
 		if !to.PriorPacketTime.IsZero() {
-			to.IPG.Push(tstamp.Sub(to.PriorPacketTime))
+			ipg := tstamp.Sub(to.PriorPacketTime)
+			to.IPG.Push(ipg)
+			if w != nil {
+				w.IPG.Push(ipg)
+			}
 		}
 		to.PriorPacketTime = tstamp
 
@@ -312,6 +698,9 @@ func Capture(pch <-chan gopacket.Packet, d *Data) {
 			to.FinSeen = true
 		}
 
+		// write event
+		enc = writeEvent(enc, &ev)
+
 		// unlock data
 		d.Unlock()
 	}
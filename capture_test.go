@@ -0,0 +1,317 @@
+package main
+
+import (
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// testSegment describes a TCP segment to synthesize for Capture, sent from
+// the client if up is true. Flags are the letters SAFRPECN, for SYN, ACK, FIN,
+// RST, PSH, ECE, CWR and NS.
+type testSegment struct {
+	at    time.Duration
+	up    bool
+	flags string
+	seq   uint32
+	ack   uint32
+	len   int
+	ecn   ECN
+	dscp  uint8
+}
+
+// testIPVersions are the IP versions Capture tests are run for.
+var testIPVersions = []struct {
+	name string
+	ip6  bool
+}{
+	{"IPv4", false},
+	{"IPv6", true},
+}
+
+// testPacket returns segment s serialized as an Ethernet frame, between the
+// client and server addresses for the IP version.
+func testPacket(t *testing.T, s testSegment, ip6 bool,
+	start time.Time) gopacket.Packet {
+	t.Helper()
+	cip, sip := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	if ip6 {
+		cip, sip = net.ParseIP("fd00::1"), net.ParseIP("fd00::2")
+	}
+	src, dst := cip, sip
+	tcp := &layers.TCP{
+		SrcPort: 40000,
+		DstPort: 5201,
+		Seq:     s.seq,
+		Ack:     s.ack,
+		Window:  65535,
+		SYN:     strings.Contains(s.flags, "S"),
+		ACK:     strings.Contains(s.flags, "A"),
+		FIN:     strings.Contains(s.flags, "F"),
+		RST:     strings.Contains(s.flags, "R"),
+		PSH:     strings.Contains(s.flags, "P"),
+		ECE:     strings.Contains(s.flags, "E"),
+		CWR:     strings.Contains(s.flags, "C"),
+		NS:      strings.Contains(s.flags, "N"),
+	}
+	if !s.up {
+		src, dst = sip, cip
+		tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+	}
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{2, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{2, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	var ip gopacket.SerializableLayer
+	tc := s.dscp<<2 | uint8(s.ecn)
+	if ip6 {
+		eth.EthernetType = layers.EthernetTypeIPv6
+		l := &layers.IPv6{Version: 6, TrafficClass: tc, HopLimit: 64,
+			NextHeader: layers.IPProtocolTCP, SrcIP: src, DstIP: dst}
+		tcp.SetNetworkLayerForChecksum(l)
+		ip = l
+	} else {
+		l := &layers.IPv4{Version: 4, TOS: tc, TTL: 64,
+			Protocol: layers.IPProtocolTCP, SrcIP: src, DstIP: dst}
+		tcp.SetNetworkLayerForChecksum(l)
+		ip = l
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp,
+		gopacket.Payload(make([]byte, s.len))); err != nil {
+		t.Fatalf("unable to serialize segment (%s)", err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet,
+		gopacket.Default)
+	m := p.Metadata()
+	m.Timestamp = start.Add(s.at)
+	m.CaptureLength = len(buf.Bytes())
+	m.Length = len(buf.Bytes())
+	return p
+}
+
+// testConfig returns the ParseConfig used for Capture tests.
+func testConfig() *ParseConfig {
+	return &ParseConfig{
+		ECT1Mode:      ModeSCE,
+		FirstLayer:    layers.LayerTypeEthernet,
+		MaxRTTAge:     DefaultMaxRTTAge,
+		BaseRTTWindow: DefaultBaseRTTWindow,
+	}
+}
+
+// testCapture runs Capture on the given segments, and returns the data.
+func testCapture(t *testing.T, segs []testSegment, ip6 bool,
+	c *ParseConfig) *Data {
+	t.Helper()
+	start := time.Unix(1000, 0)
+	pch := make(chan gopacket.Packet, len(segs))
+	for _, s := range segs {
+		pch <- testPacket(t, s, ip6, start)
+	}
+	close(pch)
+	d := NewData()
+	Capture(pch, d, c)
+	return d
+}
+
+// testFlows returns the TCP flows in d, closed flows first.
+func testFlows(d *Data) (fs []*TCPFlowData) {
+	fs = append(fs, d.TCPClosed...)
+	for _, f := range d.TCP4 {
+		fs = append(fs, f)
+	}
+	for _, f := range d.TCP6 {
+		fs = append(fs, f)
+	}
+	return
+}
+
+// testFlow returns the only TCP flow in d.
+func testFlow(t *testing.T, d *Data) *TCPFlowData {
+	t.Helper()
+	fs := testFlows(d)
+	if len(fs) != 1 {
+		t.Fatalf("%d flows, want 1", len(fs))
+	}
+	return fs[0]
+}
+
+// testTransfer is a complete connection in which the client sends three 100
+// byte data segments, acked by the server after the second and third.
+var testTransfer = []testSegment{
+	{at: 0, up: true, flags: "S", seq: 1000},
+	{at: 10 * time.Millisecond, flags: "SA", seq: 5000, ack: 1001},
+	{at: 20 * time.Millisecond, up: true, flags: "A", seq: 1001, ack: 5001},
+	{at: 21 * time.Millisecond, up: true, flags: "PA", seq: 1001, ack: 5001,
+		len: 100},
+	{at: 22 * time.Millisecond, up: true, flags: "PA", seq: 1101, ack: 5001,
+		len: 100},
+	{at: 23 * time.Millisecond, up: true, flags: "PA", seq: 1201, ack: 5001,
+		len: 100},
+	{at: 31 * time.Millisecond, flags: "A", seq: 5001, ack: 1201},
+	{at: 33 * time.Millisecond, flags: "A", seq: 5001, ack: 1301},
+	{at: 40 * time.Millisecond, up: true, flags: "FA", seq: 1301, ack: 5001},
+	{at: 50 * time.Millisecond, flags: "FA", seq: 5001, ack: 1302},
+	{at: 60 * time.Millisecond, up: true, flags: "A", seq: 1302, ack: 5002},
+}
+
+// testECNTransfer returns testTransfer with ECN negotiated, and the data
+// segments marked with the codepoint returned by ecn for each, in order.
+func testECNTransfer(ecn func(i int) ECN) (segs []testSegment) {
+	var i int
+	for _, s := range testTransfer {
+		switch {
+		case s.flags == "S":
+			s.flags = "SEC"
+		case s.flags == "SA":
+			s.flags = "SAE"
+		case s.len > 0:
+			s.ecn = ecn(i)
+			i++
+		}
+		segs = append(segs, s)
+	}
+	return
+}
+
+// withSegments returns segs with segment i replaced by r, which may be empty
+// to drop it, or hold more than one segment.
+func withSegments(segs []testSegment, i int, r ...testSegment) []testSegment {
+	s := append([]testSegment{}, segs[:i]...)
+	s = append(s, r...)
+	return append(s, segs[i+1:]...)
+}
+
+func TestCaptureSeq(t *testing.T) {
+	retx := testSegment{at: 24 * time.Millisecond, up: true, flags: "PA",
+		seq: 1101, ack: 5001, len: 100}
+	tests := []struct {
+		name       string
+		segs       []testSegment
+		dataSegs   uint64
+		retx       uint64
+		gaps       uint64
+		gapBytes   uint64
+		flightMax  float64
+		ackedBytes uint64
+		expSeq     uint32
+	}{
+		{"clean", testTransfer, 3, 0, 0, 0, 300, 301, 1302},
+		{"retransmit", withSegments(testTransfer, 5, testTransfer[5], retx),
+			4, 1, 0, 0, 300, 301, 1302},
+		{"gap", withSegments(testTransfer, 4), 2, 0, 1, 100, 300, 301, 1302},
+		{"FIN with data", append(withSegments(testTransfer[:8], 7),
+			testSegment{at: 40 * time.Millisecond, up: true, flags: "FPA",
+				seq: 1301, ack: 5001, len: 50},
+			testSegment{at: 50 * time.Millisecond, flags: "FA", seq: 5001,
+				ack: 1352},
+			testSegment{at: 60 * time.Millisecond, up: true, flags: "A",
+				seq: 1352, ack: 5002},
+		), 4, 0, 0, 0, 300, 351, 1352},
+	}
+	for _, v := range testIPVersions {
+		for _, tt := range tests {
+			t.Run(v.name+"/"+tt.name, func(t *testing.T) {
+				f := testFlow(t, testCapture(t, tt.segs, v.ip6, testConfig()))
+				if f.Up.DataSegments != tt.dataSegs {
+					t.Errorf("Up.DataSegments = %d, want %d",
+						f.Up.DataSegments, tt.dataSegs)
+				}
+				if f.Up.RetransmittedSegments != tt.retx {
+					t.Errorf("Up.RetransmittedSegments = %d, want %d",
+						f.Up.RetransmittedSegments, tt.retx)
+				}
+				if f.Up.Gaps != tt.gaps || f.Up.GapBytes != tt.gapBytes {
+					t.Errorf("Up.Gaps = %d (%d bytes), want %d (%d bytes)",
+						f.Up.Gaps, f.Up.GapBytes, tt.gaps, tt.gapBytes)
+				}
+				if f.Up.FlightBytes.Max != tt.flightMax {
+					t.Errorf("Up.FlightBytes.Max = %g, want %g",
+						f.Up.FlightBytes.Max, tt.flightMax)
+				}
+				if f.Up.ExpSeq != tt.expSeq {
+					t.Errorf("Up.ExpSeq = %d, want %d", f.Up.ExpSeq, tt.expSeq)
+				}
+				if f.Down.AckedBytes != tt.ackedBytes {
+					t.Errorf("Down.AckedBytes = %d, want %d",
+						f.Down.AckedBytes, tt.ackedBytes)
+				}
+				if f.Down.DataSegments != 0 ||
+					f.Down.RetransmittedSegments != 0 || f.Down.Gaps != 0 {
+					t.Errorf("Down data %d, retransmitted %d, gaps %d, want 0",
+						f.Down.DataSegments, f.Down.RetransmittedSegments,
+						f.Down.Gaps)
+				}
+				if f.Down.FlightBytes.Max != 0 {
+					t.Errorf("Down.FlightBytes.Max = %g, want 0",
+						f.Down.FlightBytes.Max)
+				}
+			})
+		}
+	}
+}
+
+func TestCaptureWindows(t *testing.T) {
+	type window struct {
+		start      float64
+		segments   uint64
+		dataSegs   uint64
+		ackedBytes uint64
+		ackedMbit  float64
+	}
+	// 20ms windows over testTransfer, where the segments from 20 to 33ms,
+	// including all data and its acks, are in the second window
+	up := []window{
+		{0, 1, 0, 0, 0},
+		{0.02, 4, 3, 0, 0},
+		{0.04, 1, 0, 0, 0},
+		{0.06, 1, 0, 1, 0.0004},
+	}
+	down := []window{
+		{0, 1, 0, 0, 0},
+		{0.02, 2, 0, 300, 0.12},
+		{0.04, 1, 0, 1, 0.0004},
+		{0.06, 0, 0, 0, 0},
+	}
+	for _, v := range testIPVersions {
+		t.Run(v.name, func(t *testing.T) {
+			c := testConfig()
+			c.Window = 20 * time.Millisecond
+			f := testFlow(t, testCapture(t, testTransfer, v.ip6, c))
+			r := NewTCPFlowResult(f, ModeSCE)
+			for _, d := range []struct {
+				name string
+				ws   []*TCPWindowData
+				want []window
+			}{
+				{"Up", r.Up.Windows, up},
+				{"Down", r.Down.Windows, down},
+			} {
+				if len(d.ws) != len(d.want) {
+					t.Fatalf("%s has %d windows, want %d", d.name, len(d.ws),
+						len(d.want))
+				}
+				for i, w := range d.ws {
+					g := window{w.StartSeconds, w.Segments, w.DataSegments,
+						w.AckedBytes, w.AckedMbit}
+					x := d.want[i]
+					if math.Abs(g.start-x.start) > 1e-9 ||
+						g.segments != x.segments || g.dataSegs != x.dataSegs ||
+						g.ackedBytes != x.ackedBytes ||
+						math.Abs(g.ackedMbit-x.ackedMbit) > 1e-9 {
+						t.Errorf("%s window %d = %+v, want %+v", d.name, i,
+							g, x)
+					}
+				}
+			}
+		})
+	}
+}
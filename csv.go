@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// EmitCSV writes the results as CSV, with a header row followed by one row per
+// TCP flow. Each row contains the flow's fields, followed by the one-way fields
+// for the Up direction prefixed with "Up_", then the Down direction prefixed
+// with "Down_". DurationData and Float64Data fields are flattened to _N, _Min,
+// _Max, _Mean, _Stddev, _P50, _P90, _P99 and _P999 columns, with durations in
+// milliseconds. Windows are not included.
+func (r *Result) EmitCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	var row []string
+	m := r.Meta.ECT1Mode
+	h := NewTCPFlowResult(&TCPFlowData{Up: NewTCPOneWayData(), Down: NewTCPOneWayData()}, m)
+	tcpFlowCSV(h, m, func(name, value string) {
+		row = append(row, name)
+	})
+	if err := cw.Write(row); err != nil {
+		return err
+	}
+
+	for _, f := range r.TCP {
+		row = row[:0]
+		tcpFlowCSV(f, m, func(name, value string) {
+			row = append(row, value)
+		})
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// tcpFlowCSV calls add with the name and value of each CSV column for a flow,
+// in column order, with column names according to the ECT(1) mode.
+func tcpFlowCSV(f *TCPFlowResult, m ECT1Mode, add func(name, value string)) {
+	add("Index", strconv.Itoa(f.Index))
+	add("SrcIP", f.SrcIP.String())
+	add("SrcPort", strconv.Itoa(int(f.SrcPort)))
+	add("DstIP", f.DstIP.String())
+	add("DstPort", strconv.Itoa(int(f.DstPort)))
+	add("ECNInitiated", strconv.FormatBool(f.ECNInitiated))
+	add("ECNAccepted", strconv.FormatBool(f.ECNAccepted))
+	add("MeanSeqRTTMillis", csvFloat(f.MeanSeqRTTMillis))
+	add("MeanTSValRTTMillis", csvFloat(f.MeanTSValRTTMillis))
+	var hs string
+	if f.Handshake != nil && f.Handshake.Complete {
+		hs = csvFloat(f.Handshake.RTTMillis)
+	}
+	add("HandshakeRTTMillis", hs)
+	add("End", f.End)
+	add("DurationSeconds", csvFloat(f.DurationSeconds))
+	add("AckedBytesSharePercent", csvFloat(f.AckedBytesSharePercent))
+	tcpOneWayCSV("Up_", f.Up, m, add)
+	tcpOneWayCSV("Down_", f.Down, m, add)
+}
+
+func tcpOneWayCSV(prefix string, r *TCPOneWayResult, m ECT1Mode,
+	add func(name, value string)) {
+	u := func(name string, v uint64) {
+		add(prefix+m.FieldName(name), strconv.FormatUint(v, 10))
+	}
+	f := func(name string, v float64) {
+		add(prefix+m.FieldName(name), csvFloat(v))
+	}
+	t := func(name string, v time.Time) {
+		var s string
+		if !v.IsZero() {
+			s = v.Format(time.RFC3339Nano)
+		}
+		add(prefix+name, s)
+	}
+	dd := func(name string, d *DurationData) {
+		u(name+"_N", d.N)
+		f(name+"_Min", durToMs(d.Min))
+		f(name+"_Max", durToMs(d.Max))
+		f(name+"_Mean", durToMs(d.Mean()))
+		f(name+"_Stddev", durToMs(d.Stddev()))
+		f(name+"_P50", durToMs(d.Quantile(0.5)))
+		f(name+"_P90", durToMs(d.Quantile(0.9)))
+		f(name+"_P99", durToMs(d.Quantile(0.99)))
+		f(name+"_P999", durToMs(d.Quantile(0.999)))
+	}
+	fd := func(name string, d *Float64Data) {
+		u(name+"_N", d.N)
+		f(name+"_Min", d.Min)
+		f(name+"_Max", d.Max)
+		f(name+"_Mean", d.Mean())
+		f(name+"_Stddev", d.Stddev())
+		f(name+"_P50", d.Quantile(0.5))
+		f(name+"_P90", d.Quantile(0.9))
+		f(name+"_P99", d.Quantile(0.99))
+		f(name+"_P999", d.Quantile(0.999))
+	}
+
+	u("CE", r.CE)
+	u("SCE", r.SCE)
+	u("ESCE", r.ESCE)
+	u("ECE", r.ECE)
+	u("CWR", r.CWR)
+	u("Segments", r.Segments)
+	u("DataSegments", r.DataSegments)
+	u("Acks", r.Acks)
+	u("AckedBytes", r.AckedBytes)
+	u("SackedBytes", r.SackedBytes)
+	u("ESCEAckedBytes", r.ESCEAckedBytes)
+	u("DuplicateAcks", r.DuplicateAcks)
+	u("Gaps", r.Gaps)
+	u("GapBytes", r.GapBytes)
+	u("LateSegments", r.LateSegments)
+	u("RetransmittedSegments", r.RetransmittedSegments)
+	t("FirstAckTime", r.FirstAckTime)
+	t("LastAckTime", r.LastAckTime)
+	fd("SCERunLength", &r.SCERunLength)
+	fd("FlightBytes", &r.FlightBytes)
+	dd("IPG", &r.IPG)
+	dd("SCEIPG", &r.SCEIPG)
+	dd("SeqRTT", &r.SeqRTT)
+	dd("TSValRTT", &r.TSValRTT)
+	dd("SeqQueueDelay", &r.SeqQueueDelay)
+	dd("TSValQueueDelay", &r.TSValQueueDelay)
+	dd("CEToECE", &r.CEToECE)
+	dd("ECEToCWR", &r.ECEToCWR)
+	f("SCEPercent", r.SCEPercent)
+	f("ESCEPercent", r.ESCEPercent)
+	f("ESCEAckedBytesPercent", r.ESCEAckedBytesPercent)
+	f("AckedSegmentsPercent", r.AckedSegmentsPercent)
+	f("LatePercent", r.LatePercent)
+	f("RetransmittedPercent", r.RetransmittedPercent)
+	f("LostBytesPercent", r.LostBytesPercent)
+	f("ElapsedAckTimeSeconds", r.ElapsedAckTimeSeconds)
+	f("MeanGapSizeBytes", r.MeanGapSizeBytes)
+	f("MeanSegmentSizeBytes", r.MeanSegmentSizeBytes)
+	f("GoodputMbit", r.GoodputMbit)
+	u("ZeroWindows", r.ZeroWindows)
+	u("WindowProbes", r.WindowProbes)
+	fd("ReceiveWindow", &r.ReceiveWindow)
+	f("ReceiverLimitedSeconds", r.ReceiverLimitedSeconds)
+	f("ReceiverLimitedPercent", r.ReceiverLimitedPercent)
+	u("SeqRTTDiscarded", r.SeqRTTDiscarded)
+	u("TSValRTTDiscarded", r.TSValRTTDiscarded)
+}
+
+func csvFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
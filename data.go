@@ -15,15 +15,28 @@ import (
 type Data struct {
 	sync.Mutex
 	IP   IPData
+	ICMP ICMPData
 	Meta MetaData                     `json:"-"`
 	TCP4 map[TCP4FlowKey]*TCPFlowData `json:"-"`
 	TCP6 map[TCP6FlowKey]*TCPFlowData `json:"-"`
+	// TCPClosed contains flows replaced by a new connection on the same 5-tuple
+	TCPClosed []*TCPFlowData                `json:"-"`
+	QUIC      map[QUICFlowKey]*QUICFlowData `json:"-"`
+	// QUICCIDLens contains the connection ID lengths seen for each 4-tuple.
+	QUICCIDLens map[UDPFlowKey][]int         `json:"-"`
+	IRTT        map[UDPFlowKey]*IRTTFlowData `json:"-"`
 }
 
 func NewData() *Data {
 	return &Data{
-		TCP4: make(map[TCP4FlowKey]*TCPFlowData),
-		TCP6: make(map[TCP6FlowKey]*TCPFlowData),
+		TCP4:        make(map[TCP4FlowKey]*TCPFlowData),
+		TCP6:        make(map[TCP6FlowKey]*TCPFlowData),
+		QUIC:        make(map[QUICFlowKey]*QUICFlowData),
+		QUICCIDLens: make(map[UDPFlowKey][]int),
+		IRTT:        make(map[UDPFlowKey]*IRTTFlowData),
+		ICMP: ICMPData{
+			Echo: make(map[EchoFlowKey]*EchoFlowData),
+		},
 	}
 }
 
@@ -38,11 +51,12 @@ type MetaData struct {
 	CaptureStartTime time.Time
 	CaptureEndTime   time.Time
 	PCAPStats        *pcap.Stats `json:",omitempty"`
+	ECT1Mode         ECT1Mode
 }
 
-type TCPOneWayData struct {
-	Initialized           bool `json:"-"`
-	FinSeen               bool `json:"-"`
+// TCPCounters holds the one-way counters, which are kept both for the flow as
+// a whole and for each window.
+type TCPCounters struct {
 	CE                    uint64
 	SCE                   uint64
 	ESCE                  uint64
@@ -59,73 +73,319 @@ type TCPOneWayData struct {
 	GapBytes              uint64
 	LateSegments          uint64
 	RetransmittedSegments uint64
-	FirstAckTime          time.Time
-	LastAckTime           time.Time
-	PriorPacketTime       time.Time `json:"-"`
-	PriorSCETime          time.Time `json:"-"`
-	SCERunCount           uint      `json:"-"`
-	SCERunLength          Float64Data
-	IPG                   DurationData
-	SCEIPG                DurationData
-	SeqTimes              map[uint32]time.Time `json:"-"`
-	SeqRTT                DurationData
-	TSValTimes            map[uint32]time.Time `json:"-"`
-	TSValRTT              DurationData
-	SackedBytesCtr        uint32 `json:"-"`
-	PriorAck              uint32 `json:"-"`
-	ExpSeq                uint32 `json:"-"`
-	HiTSVal               uint32 `json:"-"`
+}
+
+// Sub returns the counters in c less those in o.
+func (c TCPCounters) Sub(o TCPCounters) TCPCounters {
+	return TCPCounters{
+		CE:                    c.CE - o.CE,
+		SCE:                   c.SCE - o.SCE,
+		ESCE:                  c.ESCE - o.ESCE,
+		ECE:                   c.ECE - o.ECE,
+		CWR:                   c.CWR - o.CWR,
+		Segments:              c.Segments - o.Segments,
+		DataSegments:          c.DataSegments - o.DataSegments,
+		Acks:                  c.Acks - o.Acks,
+		AckedBytes:            c.AckedBytes - o.AckedBytes,
+		SackedBytes:           c.SackedBytes - o.SackedBytes,
+		ESCEAckedBytes:        c.ESCEAckedBytes - o.ESCEAckedBytes,
+		DuplicateAcks:         c.DuplicateAcks - o.DuplicateAcks,
+		Gaps:                  c.Gaps - o.Gaps,
+		GapBytes:              c.GapBytes - o.GapBytes,
+		LateSegments:          c.LateSegments - o.LateSegments,
+		RetransmittedSegments: c.RetransmittedSegments - o.RetransmittedSegments,
+	}
+}
+
+// Add returns the sum of the counters in c and o.
+func (c TCPCounters) Add(o TCPCounters) TCPCounters {
+	return TCPCounters{
+		CE:                    c.CE + o.CE,
+		SCE:                   c.SCE + o.SCE,
+		ESCE:                  c.ESCE + o.ESCE,
+		ECE:                   c.ECE + o.ECE,
+		CWR:                   c.CWR + o.CWR,
+		Segments:              c.Segments + o.Segments,
+		DataSegments:          c.DataSegments + o.DataSegments,
+		Acks:                  c.Acks + o.Acks,
+		AckedBytes:            c.AckedBytes + o.AckedBytes,
+		SackedBytes:           c.SackedBytes + o.SackedBytes,
+		ESCEAckedBytes:        c.ESCEAckedBytes + o.ESCEAckedBytes,
+		DuplicateAcks:         c.DuplicateAcks + o.DuplicateAcks,
+		Gaps:                  c.Gaps + o.Gaps,
+		GapBytes:              c.GapBytes + o.GapBytes,
+		LateSegments:          c.LateSegments + o.LateSegments,
+		RetransmittedSegments: c.RetransmittedSegments + o.RetransmittedSegments,
+	}
+}
+
+// ECNCounters holds counts of the IP-level ECN codepoints.
+type ECNCounters struct {
+	NotECT uint64
+	SCE    uint64
+	ECT0   uint64
+	CE     uint64
+}
+
+// Add increments the counter for the given codepoint.
+func (c *ECNCounters) Add(e ECN) {
+	switch e {
+	case NotECT:
+		c.NotECT++
+	case SCE:
+		c.SCE++
+	case ECT0:
+		c.ECT0++
+	case CE:
+		c.CE++
+	}
+}
+
+// AddN adds n to the counter for the given codepoint.
+func (c *ECNCounters) AddN(e ECN, n uint64) {
+	switch e {
+	case NotECT:
+		c.NotECT += n
+	case SCE:
+		c.SCE += n
+	case ECT0:
+		c.ECT0 += n
+	case CE:
+		c.CE += n
+	}
+}
+
+// Total returns the sum of all counters.
+func (c *ECNCounters) Total() uint64 {
+	return c.NotECT + c.SCE + c.ECT0 + c.CE
+}
+
+type TCPOneWayData struct {
+	Initialized bool `json:"-"`
+	FinSeen     bool `json:"-"`
+	TCPCounters
+	FirstAckTime    time.Time
+	LastAckTime     time.Time
+	PriorPacketTime time.Time `json:"-"`
+	PriorSCETime    time.Time `json:"-"`
+	SCERunCount     uint      `json:"-"`
+	SCERunLength    Float64Data
+	// FlightBytes is the sent but unacked bytes in this direction, sampled
+	// after each data segment and each ack from the reverse direction
+	FlightBytes Float64Data
+	IPG         DurationData
+	SCEIPG      DurationData
+	SeqTimes    *TimeQueue `json:"-"`
+	SeqRTT      DurationData
+	TSValTimes  *TimeQueue `json:"-"`
+	TSValRTT    DurationData
+	// SeqQueueDelay and TSValQueueDelay are the RTT less the base RTT, the
+	// minimum RTT over a sliding window, for each RTT method
+	SeqQueueDelay   DurationData
+	TSValQueueDelay DurationData
+	SeqBaseRTT      MinFilter `json:"-"`
+	TSValBaseRTT    MinFilter `json:"-"`
+	// CEToECE is the time from a CE marked data segment in this direction to
+	// the first ECE on the reverse path
+	CEToECE DurationData
+	// ECEToCWR is the time from the first ECE on the reverse path to CWR in
+	// this direction
+	ECEToCWR       DurationData
+	PendingCETime  time.Time `json:"-"`
+	PendingECETime time.Time `json:"-"`
+	PriorECE       bool      `json:"-"`
+	// options offered in the SYN, where WindowScale is -1 if none
+	WindowScale   int
+	MSS           uint16 `json:",omitempty"`
+	SACKPermitted bool
+	Timestamps    bool
+	// ReceiveWindow is the effective receive window advertised in this
+	// direction, in bytes, recorded only if both SYNs were seen
+	ReceiveWindow Float64Data
+	// ZeroWindows counts transitions to a zero receive window
+	ZeroWindows uint64
+	// WindowProbes counts segments of at most one byte sent in this
+	// direction while the reverse direction advertised a zero window
+	WindowProbes    uint64
+	ReceiverLimited time.Duration `json:"-"`
+	RwndLimited     bool          `json:"-"`
+	RwndLimitedTime time.Time     `json:"-"`
+	Rwnd            uint32        `json:"-"`
+	RwndKnown       bool          `json:"-"`
+	MaxSegLen       uint32        `json:"-"`
+	SYNSeen         bool          `json:"-"`
+	ISN             uint32        `json:"-"`
+	// TunnelECN counts tunneled data segments by outer and inner ECN
+	TunnelECN ECNMatrix
+	// DataECN counts data segments by ECN codepoint
+	DataECN ECNCounters
+	// ECT1ToCE counts CE marked data segments following an ECT(1) one
+	ECT1ToCE     uint64 `json:",omitempty"`
+	PriorDataECN ECN    `json:"-"`
+	ECNTracking  ECNTracking
+	// SCEFeedback verifies the ESCE echoes of this direction's SCE marks
+	SCEFeedback SCEFeedbackData
+	// ECNBytes counts data segment payload bytes by ECN codepoint
+	ECNBytes ECNCounters
+	// AccECN is the feedback sent in this direction, if negotiated
+	AccECN         *AccECNData      `json:",omitempty"`
+	Windows        []*TCPWindowData `json:",omitempty"`
+	SackedBytesCtr uint32           `json:"-"`
+	PriorAck       uint32           `json:"-"`
+	ExpSeq         uint32           `json:"-"`
+	HiTSVal        uint32           `json:"-"`
 }
 
 func NewTCPOneWayData() *TCPOneWayData {
 	return &TCPOneWayData{
-		TSValTimes: make(map[uint32]time.Time),
-		SeqTimes:   make(map[uint32]time.Time),
+		TSValTimes:  NewTimeQueue(),
+		SeqTimes:    NewTimeQueue(),
+		WindowScale: -1,
 	}
 }
 
+// Flight returns the bytes in flight in this direction given the most recent
+// cumulative ack from the reverse direction, or false if the ack is beyond the
+// highest sequence number seen, as when segments weren't captured.
+func (o *TCPOneWayData) Flight(ack uint32) (uint32, bool) {
+	n := o.ExpSeq - ack
+	if n > math.MaxUint32/2 {
+		return 0, false
+	}
+	return n, true
+}
+
+// Window returns the window with index i, closing the current window and
+// starting a new one if i is later than the current window. Packets with
+// timestamps that go backwards are counted in the current window.
+func (o *TCPOneWayData) Window(i int, interval time.Duration) *TCPWindowData {
+	if n := len(o.Windows); n > 0 {
+		w := o.Windows[n-1]
+		if i <= w.index {
+			return w
+		}
+		w.Close(o.TCPCounters)
+	}
+	w := &TCPWindowData{
+		StartSeconds: (time.Duration(i) * interval).Seconds(),
+		index:        i,
+		interval:     interval,
+		start:        o.TCPCounters,
+	}
+	w.FlightBytes.DisableHistogram()
+	w.IPG.DisableHistogram()
+	w.SeqRTT.DisableHistogram()
+	w.TSValRTT.DisableHistogram()
+	w.SeqQueueDelay.DisableHistogram()
+	w.TSValQueueDelay.DisableHistogram()
+	o.Windows = append(o.Windows, w)
+	return w
+}
+
+// TCPWindowData records one-way stats for a fixed interval of capture time.
+// Counters are only valid after Close is called.
+type TCPWindowData struct {
+	StartSeconds float64
+	TCPCounters
+	AckedMbit       float64
+	FlightBytes     Float64Data
+	IPG             DurationData
+	SeqRTT          DurationData
+	TSValRTT        DurationData
+	SeqQueueDelay   DurationData
+	TSValQueueDelay DurationData
+	index           int
+	interval        time.Duration
+	start           TCPCounters
+}
+
+// Close sets the window's counters from the one-way counters c at the end of
+// the window. It may be called more than once for the last, open window.
+func (w *TCPWindowData) Close(c TCPCounters) {
+	w.TCPCounters = c.Sub(w.start)
+	w.AckedMbit = float64(w.AckedBytes) * 8 / 1000000 / w.interval.Seconds()
+}
+
 type TCPFlowData struct {
-	Index        int `json:"-"`
+	Index        int      `json:"-"`
+	VLANs        []uint16 `json:",omitempty"`
+	Tunnel       []string `json:",omitempty"`
 	SrcIP        net.IP
 	SrcPort      layers.TCPPort
 	DstIP        net.IP
 	DstPort      layers.TCPPort
 	ECNInitiated bool
 	ECNAccepted  bool
-	Up           *TCPOneWayData
-	Down         *TCPOneWayData
+	// AccECN negotiation status, with ECNInitiated and ECNAccepted also true
+	AccECNInitiated bool
+	AccECNAccepted  bool
+	// ICMP errors referencing the flow, and the lowest reported MTU, omitted
+	// for flows without any
+	ICMPDestinationUnreachable uint64 `json:",omitempty"`
+	ICMPPacketTooBig           uint64 `json:",omitempty"`
+	ICMPMinMTU                 uint32 `json:",omitempty"`
+	// HandshakeData is the 3-way handshake, if the SYN was seen
+	HandshakeData *TCPHandshakeData `json:"-"`
+	// RST is the direction of the first RST, if any
+	RST       string `json:",omitempty"`
+	FirstTime time.Time
+	LastTime  time.Time
+	Up        *TCPOneWayData
+	Down      *TCPOneWayData
 }
 
+func NewTCPFlowData(index int, srcIP, dstIP net.IP, srcPort,
+	dstPort layers.TCPPort, t time.Time) *TCPFlowData {
+	return &TCPFlowData{
+		Index:     index,
+		SrcIP:     srcIP,
+		DstIP:     dstIP,
+		SrcPort:   srcPort,
+		DstPort:   dstPort,
+		FirstTime: t,
+		Up:        NewTCPOneWayData(),
+		Down:      NewTCPOneWayData(),
+	}
+}
+
+// TCP4FlowKey identifies an IPv4 TCP flow by its 5-tuple, plus the VLAN stack
+// key, which is zero unless flows are split by VLAN.
 type TCP4FlowKey struct {
 	SrcIP   [4]byte
 	SrcPort layers.TCPPort
 	DstIP   [4]byte
 	DstPort layers.TCPPort
+	VLAN    uint32
 }
 
 func (k TCP4FlowKey) Reverse() TCP4FlowKey {
-	return TCP4FlowKey{k.DstIP, k.DstPort, k.SrcIP, k.SrcPort}
+	return TCP4FlowKey{k.DstIP, k.DstPort, k.SrcIP, k.SrcPort, k.VLAN}
 }
 
+// TCP6FlowKey identifies an IPv6 TCP flow by its 5-tuple, plus the VLAN stack
+// key, which is zero unless flows are split by VLAN.
 type TCP6FlowKey struct {
 	SrcIP   [16]byte
 	SrcPort layers.TCPPort
 	DstIP   [16]byte
 	DstPort layers.TCPPort
+	VLAN    uint32
 }
 
 func (k TCP6FlowKey) Reverse() TCP6FlowKey {
-	return TCP6FlowKey{k.DstIP, k.DstPort, k.SrcIP, k.SrcPort}
+	return TCP6FlowKey{k.DstIP, k.DstPort, k.SrcIP, k.SrcPort, k.VLAN}
 }
 
 // DurationData records min, max, mean and variance for a time.Duration.
 type DurationData struct {
-	N    uint64
-	Min  time.Duration
-	Max  time.Duration
-	m    float64
-	s    float64
-	mean float64
+	N           uint64
+	Min         time.Duration
+	Max         time.Duration
+	m           float64
+	s           float64
+	mean        float64
+	h           Histogram
+	noHistogram bool
 }
 
 func (u *DurationData) Push(d time.Duration) {
@@ -145,6 +405,51 @@ func (u *DurationData) Push(d time.Duration) {
 	fd := float64(d)
 	u.mean += (fd - om) / float64(u.N)
 	u.s += (fd - om) * (fd - u.mean)
+	if !u.noHistogram {
+		u.h.Add(fd)
+	}
+}
+
+// Merge adds the samples recorded in o.
+func (u *DurationData) Merge(o *DurationData) {
+	if o.N == 0 {
+		return
+	}
+	if u.N == 0 || o.Min < u.Min {
+		u.Min = o.Min
+	}
+	if u.N == 0 || o.Max > u.Max {
+		u.Max = o.Max
+	}
+	n := float64(u.N + o.N)
+	delta := o.mean - u.mean
+	u.s += o.s + delta*delta*float64(u.N)*float64(o.N)/n
+	u.mean += delta * float64(o.N) / n
+	u.N += o.N
+	u.h.Merge(&o.h)
+}
+
+// DisableHistogram stops recording the histogram, so percentiles aren't
+// available.
+func (d *DurationData) DisableHistogram() {
+	d.noHistogram = true
+}
+
+// Quantile returns the estimated duration at quantile q, from 0 to 1.
+func (d *DurationData) Quantile(q float64) time.Duration {
+	v := time.Duration(d.h.Quantile(q))
+	if v < d.Min {
+		return d.Min
+	}
+	if v > d.Max {
+		return d.Max
+	}
+	return v
+}
+
+// Histogram returns the histogram, in nanoseconds.
+func (d *DurationData) Histogram() *Histogram {
+	return &d.h
 }
 
 func (d *DurationData) IsZero() bool {
@@ -182,6 +487,7 @@ func (d *DurationData) MarshalJSON() ([]byte, error) {
 		Stddev     float64
 		Variance   float64
 		Burstiness float64
+		*Percentiles
 	}
 
 	if d.N == 0 {
@@ -196,6 +502,15 @@ func (d *DurationData) MarshalJSON() ([]byte, error) {
 		nsToMs(math.Sqrt(d.Variance())),
 		nsToMs(nsToMs(d.Variance())),
 		nsToMs(d.Burstiness()),
+		nil,
+	}
+	if !d.noHistogram {
+		j.Percentiles = &Percentiles{
+			durToMs(d.Quantile(0.5)),
+			durToMs(d.Quantile(0.9)),
+			durToMs(d.Quantile(0.99)),
+			durToMs(d.Quantile(0.999)),
+		}
 	}
 
 	return json.Marshal(j)
@@ -209,14 +524,25 @@ func durToMs(d time.Duration) float64 {
 	return nsToMs(float64(d.Nanoseconds()))
 }
 
-// Float64Data records min, max, mean and variance for a float64.
+// Percentiles contains the percentiles output for DurationData and
+// Float64Data.
+type Percentiles struct {
+	P50  float64
+	P90  float64
+	P99  float64
+	P999 float64
+}
+
+// Float64Data records min, max, mean, variance and a histogram for a float64.
 type Float64Data struct {
-	N    uint64
-	Min  float64
-	Max  float64
-	m    float64
-	s    float64
-	mean float64
+	N           uint64
+	Min         float64
+	Max         float64
+	m           float64
+	s           float64
+	mean        float64
+	h           Histogram
+	noHistogram bool
 }
 
 func (d *Float64Data) Push(f float64) {
@@ -236,6 +562,44 @@ func (d *Float64Data) Push(f float64) {
 	fd := f
 	d.mean += (fd - om) / float64(d.N)
 	d.s += (fd - om) * (fd - d.mean)
+	if !d.noHistogram {
+		d.h.Add(f)
+	}
+}
+
+// Merge adds the samples recorded in o.
+func (d *Float64Data) Merge(o *Float64Data) {
+	if o.N == 0 {
+		return
+	}
+	if d.N == 0 || o.Min < d.Min {
+		d.Min = o.Min
+	}
+	if d.N == 0 || o.Max > d.Max {
+		d.Max = o.Max
+	}
+	n := float64(d.N + o.N)
+	delta := o.mean - d.mean
+	d.s += o.s + delta*delta*float64(d.N)*float64(o.N)/n
+	d.mean += delta * float64(o.N) / n
+	d.N += o.N
+	d.h.Merge(&o.h)
+}
+
+// DisableHistogram stops recording the histogram, so percentiles aren't
+// available.
+func (d *Float64Data) DisableHistogram() {
+	d.noHistogram = true
+}
+
+// Quantile returns the estimated value at quantile q, from 0 to 1.
+func (d *Float64Data) Quantile(q float64) float64 {
+	return math.Max(d.Min, math.Min(d.Max, d.h.Quantile(q)))
+}
+
+// Histogram returns the histogram.
+func (d *Float64Data) Histogram() *Histogram {
+	return &d.h
 }
 
 func (d *Float64Data) IsZero() bool {
@@ -273,6 +637,7 @@ func (d *Float64Data) MarshalJSON() ([]byte, error) {
 		Stddev     float64
 		Variance   float64
 		Burstiness float64
+		*Percentiles
 	}
 
 	if d.N == 0 {
@@ -287,6 +652,15 @@ func (d *Float64Data) MarshalJSON() ([]byte, error) {
 		d.Stddev(),
 		d.Variance(),
 		d.Burstiness(),
+		nil,
+	}
+	if !d.noHistogram {
+		j.Percentiles = &Percentiles{
+			d.Quantile(0.5),
+			d.Quantile(0.9),
+			d.Quantile(0.99),
+			d.Quantile(0.999),
+		}
 	}
 
 	return json.Marshal(j)
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// TCPEvent records how Capture processed a single TCP segment, so the
+// classification of segments as gaps, retransmissions or late may be audited.
+type TCPEvent struct {
+	Time           time.Time
+	Index          int
+	Direction      string
+	Seq            uint32
+	Ack            uint32
+	SegLen         uint32
+	ECN            string
+	OuterECN       string `json:",omitempty"`
+	NS             bool
+	ECE            bool
+	CWR            bool
+	RST            bool
+	TSVal          uint32
+	TSEcr          uint32
+	SeqRTTMillis   float64 `json:",omitempty"`
+	TSValRTTMillis float64 `json:",omitempty"`
+	FlightBytes    uint32  `json:",omitempty"`
+	DuplicateAck   bool
+	Gap            bool
+	Retransmitted  bool
+	Late           bool
+}
+
+// writeEvent writes ev to enc, if not nil, and returns the encoder to use for
+// later events, which is nil if the write failed, disabling the event log.
+func writeEvent(enc *json.Encoder, ev *TCPEvent) *json.Encoder {
+	if enc == nil {
+		return nil
+	}
+	if err := enc.Encode(ev); err != nil {
+		log.Printf("unable to write event (%s), disabling event log", err)
+		return nil
+	}
+	return enc
+}
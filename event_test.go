@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCaptureEvents(t *testing.T) {
+	retx := testSegment{at: 24 * time.Millisecond, up: true, flags: "PA",
+		seq: 1101, ack: 5001, len: 100}
+	rst := testSegment{at: 40 * time.Millisecond, flags: "RA", seq: 5001,
+		ack: 1301}
+	tests := []struct {
+		name          string
+		segs          []testSegment
+		retransmitted int
+		gap           int
+		rst           int
+	}{
+		{"clean", testTransfer, -1, -1, -1},
+		{"retransmit", withSegments(testTransfer, 5, testTransfer[5], retx),
+			6, -1, -1},
+		{"gap", withSegments(testTransfer, 4), -1, 4, -1},
+		{"reset", append(append([]testSegment{}, testTransfer[:8]...), rst),
+			-1, -1, 8},
+	}
+	for _, v := range testIPVersions {
+		for _, tt := range tests {
+			t.Run(v.name+"/"+tt.name, func(t *testing.T) {
+				var b bytes.Buffer
+				c := testConfig()
+				c.Events = &b
+				testCapture(t, tt.segs, v.ip6, c)
+
+				var evs []TCPEvent
+				dec := json.NewDecoder(&b)
+				for dec.More() {
+					var ev TCPEvent
+					if err := dec.Decode(&ev); err != nil {
+						t.Fatalf("unable to decode event (%s)", err)
+					}
+					evs = append(evs, ev)
+				}
+				if len(evs) != len(tt.segs) {
+					t.Fatalf("%d events, want %d", len(evs), len(tt.segs))
+				}
+				for i, ev := range evs {
+					s := tt.segs[i]
+					dir := "Up"
+					if !s.up {
+						dir = "Down"
+					}
+					if ev.Direction != dir || ev.Seq != s.seq ||
+						ev.SegLen != uint32(s.len) {
+						t.Errorf("event %d %s seq %d len %d, want %s seq %d "+
+							"len %d", i, ev.Direction, ev.Seq, ev.SegLen, dir,
+							s.seq, s.len)
+					}
+					if ev.Retransmitted != (i == tt.retransmitted) {
+						t.Errorf("event %d Retransmitted = %t", i,
+							ev.Retransmitted)
+					}
+					if ev.Gap != (i == tt.gap) {
+						t.Errorf("event %d Gap = %t", i, ev.Gap)
+					}
+					if ev.RST != (i == tt.rst) {
+						t.Errorf("event %d RST = %t", i, ev.RST)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// tcpOneWayStats calls dd and fd with the name of each DurationData and
+// Float64Data stat in a one-way result.
+func tcpOneWayStats(r *TCPOneWayResult, dd func(string, *DurationData),
+	fd func(string, *Float64Data)) {
+	fd("SCERunLength", &r.SCERunLength)
+	fd("FlightBytes", &r.FlightBytes)
+	dd("IPG", &r.IPG)
+	dd("SCEIPG", &r.SCEIPG)
+	dd("SeqRTT", &r.SeqRTT)
+	dd("TSValRTT", &r.TSValRTT)
+	dd("SeqQueueDelay", &r.SeqQueueDelay)
+	dd("TSValQueueDelay", &r.TSValQueueDelay)
+	dd("CEToECE", &r.CEToECE)
+	dd("ECEToCWR", &r.ECEToCWR)
+	fd("ReceiveWindow", &r.ReceiveWindow)
+}
+
+// WriteHistograms writes the histograms for the TCP one-way stats to the named
+// file as CSV, with one row per non-empty bucket. Bucket bounds for durations
+// are in milliseconds.
+func (r *Result) WriteHistograms(name string) (err error) {
+	var f *os.File
+	if f, err = os.Create(name); err != nil {
+		return fmt.Errorf("unable to create histogram file \"%s\" (%s)", name, err)
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	cw := csv.NewWriter(f)
+	cw.Write([]string{"Index", "Direction", "Stat", "Lower", "Upper", "Count"})
+	m := r.Meta.ECT1Mode
+	for _, fl := range r.TCP {
+		for _, o := range []struct {
+			dir string
+			r   *TCPOneWayResult
+		}{{"Up", fl.Up}, {"Down", fl.Down}} {
+			write := func(stat string, h *Histogram, scale float64) {
+				h.Buckets(func(lower, upper float64, count uint64) {
+					cw.Write([]string{
+						strconv.Itoa(fl.Index),
+						o.dir,
+						m.FieldName(stat),
+						csvFloat(lower * scale),
+						csvFloat(upper * scale),
+						strconv.FormatUint(count, 10),
+					})
+				})
+			}
+			tcpOneWayStats(o.r, func(name string, d *DurationData) {
+				write(name, d.Histogram(), nsToMs(1))
+			}, func(name string, d *Float64Data) {
+				write(name, d.Histogram(), 1)
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// cdfStats are the names of the stats written by WriteCDFs.
+var cdfStats = []string{"SeqRTT", "TSValRTT", "IPG", "SCEIPG"}
+
+// WriteCDFs writes the CDFs of the TCP one-way SeqRTT, TSValRTT, IPG and
+// SCEIPG stats as CSV, to one file per stat named by the stat with the given
+// prefix and a .csv suffix. Each row is a point on the CDF for a flow and
+// direction, with the duration in milliseconds and the fraction of samples at
+// or below it.
+func (r *Result) WriteCDFs(prefix string) error {
+	m := r.Meta.ECT1Mode
+	for _, stat := range cdfStats {
+		if err := r.writeCDF(prefix+m.FieldName(stat)+".csv", stat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCDF writes the CDF for the named stat to the named file.
+func (r *Result) writeCDF(name, stat string) (err error) {
+	var f *os.File
+	if f, err = os.Create(name); err != nil {
+		return fmt.Errorf("unable to create CDF file \"%s\" (%s)", name, err)
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	cw := csv.NewWriter(f)
+	cw.Write([]string{"Index", "Direction", "Millis", "Fraction"})
+	for _, fl := range r.TCP {
+		for _, o := range []struct {
+			dir string
+			r   *TCPOneWayResult
+		}{{"Up", fl.Up}, {"Down", fl.Down}} {
+			tcpOneWayStats(o.r, func(name string, d *DurationData) {
+				if name != stat {
+					return
+				}
+				d.Histogram().CDF(func(v, p float64) {
+					cw.Write([]string{
+						strconv.Itoa(fl.Index),
+						o.dir,
+						csvFloat(nsToMs(v)),
+						csvFloat(p),
+					})
+				})
+			}, func(string, *Float64Data) {})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// FairnessResult contains Jain's fairness index of TCP goodput across flows,
+// for the whole capture and for each window, if enabled. Only flows with data
+// or acked bytes are included, and a flow's goodput is the sum of both
+// directions.
+type FairnessResult struct {
+	Flows     int
+	JainIndex float64
+	// GroupJainIndex is the fairness index across flow groups, of each
+	// group's mean goodput per flow, if there are multiple groups
+	GroupJainIndex float64                 `json:",omitempty"`
+	Windows        []*FairnessWindowResult `json:",omitempty"`
+}
+
+// FairnessWindowResult contains Jain's fairness index for the flows active in
+// a window, i.e. whose lifetime overlaps it. Active flows without acked bytes
+// in the window are included with zero goodput.
+type FairnessWindowResult struct {
+	StartSeconds float64
+	Flows        int
+	JainIndex    float64
+	// GroupJainIndex is the fairness index across the flow groups with
+	// active flows in the window, if there are multiple
+	GroupJainIndex float64 `json:",omitempty"`
+	index          int
+}
+
+// jainIndex returns Jain's fairness index for the given values, which is 1 if
+// all are equal, and 1/n if only one is non-zero.
+func jainIndex(x []float64) float64 {
+	var sum, sumSq float64
+	for _, v := range x {
+		sum += v
+		sumSq += v * v
+	}
+	if sumSq == 0 {
+		return 0
+	}
+	return sum * sum / (float64(len(x)) * sumSq)
+}
+
+// hasData returns true if flow f sent data or had bytes acked, in either
+// direction.
+func hasData(f *TCPFlowResult) bool {
+	return f.Up.DataSegments > 0 || f.Down.DataSegments > 0 ||
+		f.Up.AckedBytes+f.Down.AckedBytes > 0
+}
+
+// windowGoodput returns the goodput of flow f in each window, summed over
+// both directions, and the indexes of the first and last windows the flow is
+// active in. Windows without acked bytes have zero goodput. The returned
+// interval is zero if windows are not enabled, or f has no windows.
+func windowGoodput(f *TCPFlowResult, start time.Time) (g map[int]float64,
+	first, last int, interval time.Duration) {
+	g = make(map[int]float64)
+	for _, ws := range [][]*TCPWindowData{f.Up.Windows, f.Down.Windows} {
+		for _, w := range ws {
+			g[w.index] += w.AckedMbit
+			interval = w.interval
+		}
+	}
+	if interval == 0 {
+		return
+	}
+	first = int(f.FirstTime.Sub(start) / interval)
+	last = int(f.LastTime.Sub(start) / interval)
+	return
+}
+
+// NewFairnessResult returns the fairness results for the given flows, and
+// sets each flow's share of the total acked bytes. start is the capture start
+// time, from which windows are indexed.
+func NewFairnessResult(fs []*TCPFlowResult, start time.Time) (r *FairnessResult) {
+	r = &FairnessResult{}
+
+	var total uint64
+	var gp []float64
+	win := make(map[int][]float64)
+	var interval time.Duration
+	for _, f := range fs {
+		if !hasData(f) {
+			continue
+		}
+		total += f.Up.AckedBytes + f.Down.AckedBytes
+		gp = append(gp, f.Up.GoodputMbit+f.Down.GoodputMbit)
+
+		// include the flow in each window it's active in, even if starved
+		wg, first, last, iv := windowGoodput(f, start)
+		if iv == 0 {
+			continue
+		}
+		interval = iv
+		for i := first; i <= last; i++ {
+			win[i] = append(win[i], wg[i])
+		}
+	}
+
+	r.Flows = len(gp)
+	r.JainIndex = jainIndex(gp)
+	for _, f := range fs {
+		if total > 0 {
+			a := f.Up.AckedBytes + f.Down.AckedBytes
+			f.AckedBytesSharePercent = 100 * float64(a) / float64(total)
+		}
+	}
+
+	for i, g := range win {
+		r.Windows = append(r.Windows, &FairnessWindowResult{
+			StartSeconds: (time.Duration(i) * interval).Seconds(),
+			Flows:        len(g),
+			JainIndex:    jainIndex(g),
+			index:        i,
+		})
+	}
+	sort.Slice(r.Windows, func(i, j int) bool {
+		return r.Windows[i].index < r.Windows[j].index
+	})
+
+	return
+}
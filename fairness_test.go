@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestJainIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		x    []float64
+		want float64
+	}{
+		{"empty", nil, 0},
+		{"all zero", []float64{0, 0}, 0},
+		{"single", []float64{3}, 1},
+		{"equal", []float64{5, 5, 5, 5}, 1},
+		{"one of two", []float64{7, 0}, 0.5},
+		{"one of four", []float64{7, 0, 0, 0}, 0.25},
+		{"unequal", []float64{1, 2}, 0.9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if j := jainIndex(tt.x); math.Abs(j-tt.want) > 1e-9 {
+				t.Errorf("jainIndex(%v) = %g, want %g", tt.x, j, tt.want)
+			}
+		})
+	}
+}
+
+// fairnessFlow returns a flow active from first to last, with the given
+// goodput in each one second window it acked bytes in.
+func fairnessFlow(start time.Time, first, last time.Duration,
+	goodput map[int]float64) *TCPFlowResult {
+	d := &TCPFlowData{
+		FirstTime: start.Add(first),
+		LastTime:  start.Add(last),
+		Up:        &TCPOneWayData{},
+		Down:      &TCPOneWayData{},
+	}
+	d.Up.DataSegments = 1
+	for i, g := range goodput {
+		d.Up.Windows = append(d.Up.Windows, &TCPWindowData{
+			AckedMbit: g,
+			index:     i,
+			interval:  time.Second,
+		})
+	}
+	return &TCPFlowResult{
+		TCPFlowData: d,
+		Up:          &TCPOneWayResult{TCPOneWayData: d.Up},
+		Down:        &TCPOneWayResult{TCPOneWayData: d.Down},
+	}
+}
+
+func TestFairnessWindows(t *testing.T) {
+	start := time.Unix(0, 0)
+	fs := []*TCPFlowResult{
+		fairnessFlow(start, 0, 2500*time.Millisecond,
+			map[int]float64{0: 10, 1: 10, 2: 10}),
+		// starved for its whole lifetime
+		fairnessFlow(start, 500*time.Millisecond, 1500*time.Millisecond,
+			map[int]float64{0: 0}),
+	}
+	want := []FairnessWindowResult{
+		{StartSeconds: 0, Flows: 2, JainIndex: 0.5},
+		{StartSeconds: 1, Flows: 2, JainIndex: 0.5},
+		{StartSeconds: 2, Flows: 1, JainIndex: 1},
+	}
+	r := NewFairnessResult(fs, start)
+	if r.Flows != 2 {
+		t.Errorf("Flows = %d, want 2", r.Flows)
+	}
+	if len(r.Windows) != len(want) {
+		t.Fatalf("%d windows, want %d", len(r.Windows), len(want))
+	}
+	for i, w := range r.Windows {
+		if w.StartSeconds != want[i].StartSeconds || w.Flows != want[i].Flows ||
+			w.JainIndex != want[i].JainIndex {
+			t.Errorf("window %d = %+v, want %+v", i, *w, want[i])
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"time"
+)
+
+// SCEFeedbackMaxPending is the maximum number of data segments awaiting an
+// ack for SCE feedback verification, to bound memory when acks aren't seen.
+const SCEFeedbackMaxPending = 1 << 16
+
+// SCEFeedbackData verifies SCE feedback by correlating the data segments sent
+// in one direction with the ESCE (NS) flag on the acks that cover them.
+//
+// A data segment that arrived SCE marked is a TruePositive if the first ack
+// covering it carried ESCE, and Missed otherwise. An ack carrying ESCE that
+// covers no SCE marked segments is a FalsePositive, which may also result from
+// SCE marks applied after the capture point. EchoDelay is the time from each
+// true positive segment to its ack. Duplicate acks are not considered.
+type SCEFeedbackData struct {
+	TruePositives  uint64
+	FalsePositives uint64
+	Missed         uint64
+	Dropped        uint64
+	EchoDelay      DurationData
+	pending        []pendingSegment
+}
+
+type pendingSegment struct {
+	end  uint32
+	sce  bool
+	time time.Time
+}
+
+// Segment records a data segment ending at sequence number end. Segments that
+// don't advance past those already recorded are ignored.
+func (s *SCEFeedbackData) Segment(end uint32, sce bool, t time.Time) {
+	if n := len(s.pending); n > 0 && seqLE(end, s.pending[n-1].end) {
+		return
+	}
+	if len(s.pending) >= SCEFeedbackMaxPending {
+		s.Dropped++
+		return
+	}
+	s.pending = append(s.pending, pendingSegment{end, sce, t})
+}
+
+// Ack records a cumulative ack from the other direction, where esce is true
+// if the ack carried ESCE.
+func (s *SCEFeedbackData) Ack(ack uint32, esce bool, t time.Time) {
+	var n, sce int
+	for len(s.pending) > 0 && seqLE(s.pending[0].end, ack) {
+		p := s.pending[0]
+		s.pending = s.pending[1:]
+		n++
+		if p.sce {
+			sce++
+			if esce {
+				s.TruePositives++
+				s.EchoDelay.Push(t.Sub(p.time))
+			} else {
+				s.Missed++
+			}
+		}
+	}
+	if esce && n > 0 && sce == 0 {
+		s.FalsePositives++
+	}
+}
+
+// seqLE returns true if sequence number a is at or before b.
+func seqLE(a, b uint32) bool {
+	return b-a < 1<<31
+}
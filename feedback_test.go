@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCaptureSCEFeedback(t *testing.T) {
+	// marks returns the ECN transfer with SCE on the given data segments, and
+	// ESCE on the given acks from the server, by index in testTransfer
+	marks := func(sce []int, esce []int) []testSegment {
+		segs := testECNTransfer(func(int) ECN { return ECT0 })
+		for _, i := range sce {
+			segs[i].ecn = SCE
+		}
+		for _, i := range esce {
+			segs[i].flags += "N"
+		}
+		return segs
+	}
+	tests := []struct {
+		name           string
+		segs           []testSegment
+		truePositives  uint64
+		falsePositives uint64
+		missed         uint64
+	}{
+		{"none", marks(nil, nil), 0, 0, 0},
+		{"echoed", marks([]int{4}, []int{6}), 1, 0, 0},
+		{"echoed late", marks([]int{5}, []int{7}), 1, 0, 0},
+		{"missed", marks([]int{4}, nil), 0, 0, 1},
+		{"false positive", marks(nil, []int{7}), 0, 1, 0},
+		{"all", marks([]int{3, 4, 5}, []int{6, 7}), 3, 0, 0},
+	}
+	for _, v := range testIPVersions {
+		for _, tt := range tests {
+			t.Run(v.name+"/"+tt.name, func(t *testing.T) {
+				f := testFlow(t, testCapture(t, tt.segs, v.ip6, testConfig()))
+				s := f.Up.SCEFeedback
+				if s.TruePositives != tt.truePositives ||
+					s.FalsePositives != tt.falsePositives ||
+					s.Missed != tt.missed {
+					t.Errorf("true/false positives, missed = %d/%d/%d, "+
+						"want %d/%d/%d", s.TruePositives, s.FalsePositives,
+						s.Missed, tt.truePositives, tt.falsePositives, tt.missed)
+				}
+				if s.EchoDelay.N != tt.truePositives {
+					t.Errorf("EchoDelay.N = %d, want %d", s.EchoDelay.N,
+						tt.truePositives)
+				}
+				if d := f.Down.SCEFeedback; d.TruePositives != 0 ||
+					d.FalsePositives != 0 || d.Missed != 0 {
+					t.Errorf("Down feedback %d/%d/%d, want 0", d.TruePositives,
+						d.FalsePositives, d.Missed)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// FlowGroup defines a named group of TCP flows, matched by port, CIDR, DSCP or
+// a regular expression on the flow tuple.
+type FlowGroup struct {
+	Name string
+	spec string
+	port layers.TCPPort
+	net  *net.IPNet
+	dscp int
+	re   *regexp.Regexp
+}
+
+// ParseFlowGroup parses a flow group definition of the form name=kind:value,
+// where kind is one of:
+//
+//   - port: matches flows with the given source or destination port
+//   - net: matches flows with a source or destination IP in the given CIDR
+//   - dscp: matches flows whose first DSCP in either direction is the given
+//     value
+//   - re: matches flows whose tuple, formatted as "src:port > dst:port",
+//     matches the given regular expression
+func ParseFlowGroup(s string) (g *FlowGroup, err error) {
+	ns := strings.SplitN(s, "=", 2)
+	if len(ns) != 2 || ns[0] == "" {
+		err = fmt.Errorf("invalid flow group \"%s\" (expected name=kind:value)", s)
+		return
+	}
+	kv := strings.SplitN(ns[1], ":", 2)
+	if len(kv) != 2 {
+		err = fmt.Errorf("invalid flow group \"%s\" (expected name=kind:value)", s)
+		return
+	}
+	kind, value := kv[0], kv[1]
+	g = &FlowGroup{Name: ns[0], spec: ns[1], dscp: -1}
+	switch kind {
+	case "port":
+		var p uint64
+		if p, err = strconv.ParseUint(value, 10, 16); err != nil {
+			err = fmt.Errorf("invalid port in flow group \"%s\" (%s)", s, err)
+			return
+		}
+		if p == 0 {
+			err = fmt.Errorf("invalid port in flow group \"%s\"", s)
+			return
+		}
+		g.port = layers.TCPPort(p)
+	case "net":
+		if _, g.net, err = net.ParseCIDR(value); err != nil {
+			err = fmt.Errorf("invalid CIDR in flow group \"%s\" (%s)", s, err)
+			return
+		}
+	case "dscp":
+		var d uint64
+		if d, err = strconv.ParseUint(value, 10, 6); err != nil {
+			err = fmt.Errorf("invalid DSCP in flow group \"%s\" (%s)", s, err)
+			return
+		}
+		g.dscp = int(d)
+	case "re":
+		if g.re, err = regexp.Compile(value); err != nil {
+			err = fmt.Errorf("invalid regexp in flow group \"%s\" (%s)", s, err)
+			return
+		}
+	default:
+		err = fmt.Errorf("unknown kind \"%s\" in flow group \"%s\"", kind, s)
+	}
+	return
+}
+
+// Match returns true if the flow belongs to the group.
+func (g *FlowGroup) Match(f *TCPFlowData) bool {
+	switch {
+	case g.port != 0:
+		return f.SrcPort == g.port || f.DstPort == g.port
+	case g.net != nil:
+		return g.net.Contains(f.SrcIP) || g.net.Contains(f.DstIP)
+	case g.dscp >= 0:
+		for _, o := range []*TCPOneWayData{f.Up, f.Down} {
+			if len(o.ECNTracking.DSCPs) > 0 &&
+				o.ECNTracking.DSCPs[0] == g.dscp {
+				return true
+			}
+		}
+		return false
+	case g.re != nil:
+		return g.re.MatchString(hostPort(f.SrcIP, uint16(f.SrcPort)) + " > " +
+			hostPort(f.DstIP, uint16(f.DstPort)))
+	}
+	return false
+}
+
+// FlowGroups is a list of flow groups, which may be set from repeated command
+// line flags. Each flow belongs to the first group it matches, if any.
+type FlowGroups []*FlowGroup
+
+func (gs *FlowGroups) String() string {
+	var s []string
+	for _, g := range *gs {
+		s = append(s, g.Name+"="+g.spec)
+	}
+	return strings.Join(s, ",")
+}
+
+func (gs *FlowGroups) Set(s string) error {
+	g, err := ParseFlowGroup(s)
+	if err != nil {
+		return err
+	}
+	*gs = append(*gs, g)
+	return nil
+}
+
+// TCPGroupResult contains the aggregate counters and merged stats for the TCP
+// flows in a group, by direction, and Jain's fairness index of goodput across
+// the group's flows with data.
+type TCPGroupResult struct {
+	Name      string
+	Flows     []int
+	JainIndex float64
+	Up        *TCPGroupOneWayResult
+	Down      *TCPGroupOneWayResult
+	goodput   []float64
+	windows   map[int][]float64
+}
+
+// TCPGroupOneWayResult contains the summed counters and goodput, and merged
+// stats, for one direction of the flows in a group.
+type TCPGroupOneWayResult struct {
+	TCPCounters
+	SCEPercent      float64
+	ESCEPercent     float64
+	GoodputMbit     float64
+	IPG             DurationData
+	SCEIPG          DurationData
+	SeqRTT          DurationData
+	TSValRTT        DurationData
+	SeqQueueDelay   DurationData
+	TSValQueueDelay DurationData
+}
+
+// add adds a flow's one-way result.
+func (g *TCPGroupOneWayResult) add(r *TCPOneWayResult) {
+	g.TCPCounters = g.TCPCounters.Add(r.TCPCounters)
+	g.GoodputMbit += r.GoodputMbit
+	g.IPG.Merge(&r.IPG)
+	g.SCEIPG.Merge(&r.SCEIPG)
+	g.SeqRTT.Merge(&r.SeqRTT)
+	g.TSValRTT.Merge(&r.TSValRTT)
+	g.SeqQueueDelay.Merge(&r.SeqQueueDelay)
+	g.TSValQueueDelay.Merge(&r.TSValQueueDelay)
+}
+
+// NewTCPGroupResults returns the results for each group with at least one
+// flow, in the order the groups were defined. start is the capture start
+// time, from which windows are indexed.
+func NewTCPGroupResults(gs FlowGroups, fs []*TCPFlowResult,
+	start time.Time) (r []*TCPGroupResult) {
+	m := make(map[*FlowGroup]*TCPGroupResult)
+	for _, f := range fs {
+		for _, g := range gs {
+			if !g.Match(f.TCPFlowData) {
+				continue
+			}
+			gr, ok := m[g]
+			if !ok {
+				gr = &TCPGroupResult{
+					Name:    g.Name,
+					Up:      &TCPGroupOneWayResult{},
+					Down:    &TCPGroupOneWayResult{},
+					windows: make(map[int][]float64),
+				}
+				m[g] = gr
+			}
+			gr.Flows = append(gr.Flows, f.Index)
+			gr.Up.add(f.Up)
+			gr.Down.add(f.Down)
+			if hasData(f) {
+				gr.goodput = append(gr.goodput, f.Up.GoodputMbit+f.Down.GoodputMbit)
+				wg, first, last, iv := windowGoodput(f, start)
+				for i := first; iv > 0 && i <= last; i++ {
+					gr.windows[i] = append(gr.windows[i], wg[i])
+				}
+			}
+			break
+		}
+	}
+
+	for _, g := range gs {
+		gr, ok := m[g]
+		if !ok {
+			continue
+		}
+		gr.JainIndex = jainIndex(gr.goodput)
+		for _, o := range []*TCPGroupOneWayResult{gr.Up, gr.Down} {
+			if o.DataSegments > 0 {
+				o.SCEPercent = 100 * float64(o.SCE) / float64(o.DataSegments)
+				o.ESCEPercent = 100 * float64(o.ESCE) / float64(o.DataSegments)
+			}
+		}
+		r = append(r, gr)
+	}
+	return
+}
+
+// Group sets the results for the given flow groups, and the fairness across
+// groups, for the whole capture and for each window, of each group's mean
+// goodput per flow.
+func (r *Result) Group(gs FlowGroups) {
+	r.Groups = NewTCPGroupResults(gs, r.TCP, r.Meta.CaptureStartTime)
+	if len(r.Groups) < 2 {
+		return
+	}
+	var gp [][]float64
+	for _, g := range r.Groups {
+		gp = append(gp, g.goodput)
+	}
+	r.Fairness.GroupJainIndex = jainIndex(meanGoodput(gp))
+	for _, w := range r.Fairness.Windows {
+		gp = gp[:0]
+		for _, g := range r.Groups {
+			gp = append(gp, g.windows[w.index])
+		}
+		if m := meanGoodput(gp); len(m) > 1 {
+			w.GroupJainIndex = jainIndex(m)
+		}
+	}
+}
+
+// meanGoodput returns the mean of each non-empty goodput slice in gp.
+func meanGoodput(gp [][]float64) (m []float64) {
+	for _, g := range gp {
+		if len(g) == 0 {
+			continue
+		}
+		var sum float64
+		for _, v := range g {
+			sum += v
+		}
+		m = append(m, sum/float64(len(g)))
+	}
+	return
+}
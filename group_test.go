@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseFlowGroup(t *testing.T) {
+	tests := []struct {
+		spec string
+		ok   bool
+	}{
+		{"iperf=port:5201", true},
+		{"lan=net:10.0.0.0/24", true},
+		{"lan6=net:fd00::/64", true},
+		{"l4s=dscp:45", true},
+		{"ssh=re:.*:22$", true},
+		{"colon=re:a:b", true},
+		{"", false},
+		{"iperf", false},
+		{"=port:5201", false},
+		{"iperf=5201", false},
+		{"iperf=port:", false},
+		{"iperf=port:0", false},
+		{"iperf=port:65536", false},
+		{"iperf=port:-1", false},
+		{"iperf=port:http", false},
+		{"lan=net:10.0.0.0", false},
+		{"lan=net:10.0.0.0/33", false},
+		{"l4s=dscp:64", false},
+		{"l4s=dscp:ef", false},
+		{"bad=re:(", false},
+		{"bad=proto:tcp", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			g, err := ParseFlowGroup(tt.spec)
+			if ok := err == nil; ok != tt.ok {
+				t.Fatalf("err = %v, want ok %t", err, tt.ok)
+			}
+			if tt.ok && g.Name == "" {
+				t.Errorf("empty name")
+			}
+		})
+	}
+}
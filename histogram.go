@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+)
+
+// HistogramAccuracy is the relative accuracy of Histogram quantiles.
+const HistogramAccuracy = 0.01
+
+// HistogramMaxBuckets is the maximum number of buckets in a Histogram. When
+// exceeded, the lowest buckets are collapsed, losing accuracy for the lowest
+// quantiles first.
+const HistogramMaxBuckets = 2048
+
+// histogramMinValue is the smallest value not counted in the zero bucket.
+const histogramMinValue = 1e-9
+
+var histogramGamma = (1 + HistogramAccuracy) / (1 - HistogramAccuracy)
+
+var histogramLogGamma = math.Log(histogramGamma)
+
+// Histogram is a log-bucketed histogram of non-negative values, with
+// logarithmically sized buckets so quantiles are within HistogramAccuracy of
+// the actual value, using bounded memory. Bucket i counts the values in
+// (gamma^(i-1), gamma^i], and values less than histogramMinValue, including
+// negative values, are counted in a separate zero bucket.
+type Histogram struct {
+	n      uint64
+	zero   uint64
+	counts []uint64
+	offset int
+}
+
+// Add adds a value to the histogram.
+func (h *Histogram) Add(v float64) {
+	h.n++
+	if v < histogramMinValue {
+		h.zero++
+		return
+	}
+	h.add(int(math.Ceil(math.Log(v)/histogramLogGamma)), 1)
+}
+
+// add adds k to the count for bucket i.
+func (h *Histogram) add(i int, k uint64) {
+	if len(h.counts) == 0 {
+		h.offset = i
+		h.counts = append(h.counts, 0)
+	}
+	switch {
+	case i < h.offset:
+		if h.offset+len(h.counts)-i > HistogramMaxBuckets {
+			i = h.offset
+		} else {
+			n := h.offset - i
+			c := make([]uint64, n+len(h.counts))
+			copy(c[n:], h.counts)
+			h.counts = c
+			h.offset = i
+		}
+	case i >= h.offset+len(h.counts):
+		if n := i - h.offset + 1; n > HistogramMaxBuckets {
+			h.collapse(n - HistogramMaxBuckets)
+		}
+		for i >= h.offset+len(h.counts) {
+			h.counts = append(h.counts, 0)
+		}
+	}
+	h.counts[i-h.offset] += k
+}
+
+// Merge adds the counts from histogram o.
+func (h *Histogram) Merge(o *Histogram) {
+	h.n += o.n
+	h.zero += o.zero
+	for j, k := range o.counts {
+		if k > 0 {
+			h.add(o.offset+j, k)
+		}
+	}
+}
+
+// collapse merges the lowest k buckets into the next lowest one.
+func (h *Histogram) collapse(k int) {
+	if k >= len(h.counts) {
+		var sum uint64
+		for _, c := range h.counts {
+			sum += c
+		}
+		h.counts = append(h.counts[:0], sum)
+		h.offset += k
+		return
+	}
+	for j := 0; j < k; j++ {
+		h.counts[k] += h.counts[j]
+	}
+	h.counts = append(h.counts[:0], h.counts[k:]...)
+	h.offset += k
+}
+
+// Quantile returns the estimated value at quantile q, from 0 to 1.
+func (h *Histogram) Quantile(q float64) float64 {
+	if h.n == 0 {
+		return 0
+	}
+	r := uint64(q * float64(h.n-1))
+	c := h.zero
+	if r < c {
+		return 0
+	}
+	for j, k := range h.counts {
+		if c += k; r < c {
+			return 2 * math.Pow(histogramGamma, float64(h.offset+j)) /
+				(histogramGamma + 1)
+		}
+	}
+	return math.Pow(histogramGamma, float64(h.offset+len(h.counts)-1))
+}
+
+// Buckets calls f with the lower bound, upper bound and count of each
+// non-empty bucket, in increasing order. The zero bucket has bounds of 0.
+func (h *Histogram) Buckets(f func(lower, upper float64, count uint64)) {
+	if h.zero > 0 {
+		f(0, 0, h.zero)
+	}
+	for j, k := range h.counts {
+		if k == 0 {
+			continue
+		}
+		u := math.Pow(histogramGamma, float64(h.offset+j))
+		f(u/histogramGamma, u, k)
+	}
+}
+
+// CDF calls f with the upper bound of each non-empty bucket and the fraction
+// of values at or below it, in increasing order, approximating the empirical
+// CDF to within HistogramAccuracy.
+func (h *Histogram) CDF(f func(v, p float64)) {
+	var c uint64
+	h.Buckets(func(lower, upper float64, count uint64) {
+		c += count
+		f(upper, float64(c)/float64(h.n))
+	})
+}
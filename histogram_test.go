@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// withinAccuracy returns true if v is within HistogramAccuracy of want.
+func withinAccuracy(v, want float64) bool {
+	if want == 0 {
+		return v == 0
+	}
+	return math.Abs(v-want)/want <= HistogramAccuracy
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		q      float64
+		want   float64
+	}{
+		{"empty", nil, 0.5, 0},
+		{"single", []float64{42}, 0.5, 42},
+		{"zero", []float64{0, 0, 1}, 0.5, 0},
+		{"negative", []float64{-1, 5, 5}, 0, 0},
+		{"min", []float64{3, 1, 2}, 0, 1},
+		{"median", []float64{3, 1, 2}, 0.5, 2},
+		{"max", []float64{3, 1, 2}, 1, 3},
+		{"small", []float64{1e-6, 2e-6, 3e-6}, 0.5, 2e-6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h Histogram
+			for _, v := range tt.values {
+				h.Add(v)
+			}
+			if v := h.Quantile(tt.q); !withinAccuracy(v, tt.want) {
+				t.Errorf("Quantile(%g) = %g, want %g", tt.q, v, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogramCollapse(t *testing.T) {
+	// values spanning more than HistogramMaxBuckets buckets
+	tests := []struct {
+		name   string
+		values []float64
+	}{
+		{"ascending", []float64{1e-6, 1e-3, 1, 1e6, 1e15}},
+		{"descending", []float64{1e15, 1e6, 1, 1e-3, 1e-6}},
+		{"extremes", []float64{1e-9, 1e30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h Histogram
+			for _, v := range tt.values {
+				h.Add(v)
+			}
+			if len(h.counts) > HistogramMaxBuckets {
+				t.Errorf("%d buckets, want at most %d", len(h.counts),
+					HistogramMaxBuckets)
+			}
+			var n uint64
+			for _, c := range h.counts {
+				n += c
+			}
+			if n != uint64(len(tt.values)) {
+				t.Errorf("bucket counts sum to %d, want %d", n, len(tt.values))
+			}
+			// the highest quantiles keep their accuracy, and the lowest values
+			// are collapsed into the lowest bucket
+			min := math.Min(tt.values[0], tt.values[len(tt.values)-1])
+			max := math.Max(tt.values[0], tt.values[len(tt.values)-1])
+			if v := h.Quantile(1); !withinAccuracy(v, max) {
+				t.Errorf("Quantile(1) = %g, want %g", v, max)
+			}
+			if v := h.Quantile(0); v <= min || v >= max {
+				t.Errorf("Quantile(0) = %g, want collapsed above %g", v, min)
+			}
+		})
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		q    []float64
+		want []float64
+	}{
+		{"disjoint", seq(1, 50), seq(51, 100), []float64{0, 0.5, 0.99, 1},
+			[]float64{1, 50, 99, 100}},
+		{"interleaved", seq(1, 99), seq(1, 99), []float64{0, 0.5, 1},
+			[]float64{1, 50, 99}},
+		{"into empty", nil, seq(1, 10), []float64{0, 1}, []float64{1, 10}},
+		{"from empty", seq(1, 10), nil, []float64{0, 1}, []float64{1, 10}},
+		{"zeros", []float64{0, 0}, []float64{1, 2}, []float64{0, 0.5, 1},
+			[]float64{0, 0, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var a, b Histogram
+			for _, v := range tt.a {
+				a.Add(v)
+			}
+			for _, v := range tt.b {
+				b.Add(v)
+			}
+			a.Merge(&b)
+			for i, q := range tt.q {
+				if v := a.Quantile(q); !withinAccuracy(v, tt.want[i]) {
+					t.Errorf("Quantile(%g) = %g, want %g", q, v, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHistogramMergeCollapse(t *testing.T) {
+	var a, b Histogram
+	a.Add(1e-6)
+	b.Add(1e15)
+	a.Merge(&b)
+	if len(a.counts) > HistogramMaxBuckets {
+		t.Errorf("%d buckets, want at most %d", len(a.counts),
+			HistogramMaxBuckets)
+	}
+	if v := a.Quantile(1); !withinAccuracy(v, 1e15) {
+		t.Errorf("Quantile(1) = %g, want %g", v, 1e15)
+	}
+}
+
+// seq returns the values from i to j, inclusive.
+func seq(i, j int) (s []float64) {
+	for ; i <= j; i++ {
+		s = append(s, float64(i))
+	}
+	return
+}
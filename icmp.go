@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// ICMPData holds the counts for all ICMP and ICMPv6 packets.
+type ICMPData struct {
+	Packets                uint64
+	ECN                    ECNCounters
+	DestinationUnreachable uint64
+	PacketTooBig           uint64
+	// UnmatchedErrors counts errors that don't reference a tracked TCP flow.
+	UnmatchedErrors uint64
+	Echo            map[EchoFlowKey]*EchoFlowData `json:"-"`
+}
+
+// EchoFlowKey identifies a series of echo requests by the requester's address
+// (Src), the target's address (Dst) and the echo identifier.
+type EchoFlowKey struct {
+	SrcIP [16]byte
+	DstIP [16]byte
+	ID    uint16
+}
+
+// EchoFlowData contains the information for a series of echo requests and
+// their replies.
+type EchoFlowData struct {
+	Index      int `json:"-"`
+	SrcIP      net.IP
+	DstIP      net.IP
+	ID         uint16
+	Requests   uint64
+	Replies    uint64
+	RequestECN ECNCounters
+	ReplyECN   ECNCounters
+	RTT        DurationData
+	SentTimes  map[uint16]time.Time `json:"-"`
+}
+
+// CaptureICMPv4 records an ICMP packet. vlan is the VLAN stack key used to
+// find TCP flows referenced by errors.
+func (d *Data) CaptureICMPv4(ip *layers.IPv4, icmp *layers.ICMPv4, ecn ECN,
+	vlan uint32, tstamp time.Time, flowIndex *int) {
+	d.ICMP.Packets++
+	d.ICMP.ECN.Add(ecn)
+
+	switch icmp.TypeCode.Type() {
+	case layers.ICMPv4TypeEchoRequest:
+		d.echoRequest(ip.SrcIP, ip.DstIP, icmp.Id, icmp.Seq, ecn, tstamp, flowIndex)
+	case layers.ICMPv4TypeEchoReply:
+		d.echoReply(ip.SrcIP, ip.DstIP, icmp.Id, icmp.Seq, ecn, tstamp)
+	case layers.ICMPv4TypeDestinationUnreachable:
+		// for fragmentation needed, the next-hop MTU is in the low 16 bits of
+		// the header's second word, which gopacket decodes as Seq
+		f := embeddedTCPFlowIPv4(d, icmp.LayerPayload(), vlan)
+		if icmp.TypeCode.Code() == layers.ICMPv4CodeFragmentationNeeded {
+			d.ICMP.PacketTooBig++
+			d.icmpError(f, true, uint32(icmp.Seq))
+		} else {
+			d.ICMP.DestinationUnreachable++
+			d.icmpError(f, false, 0)
+		}
+	}
+}
+
+// CaptureICMPv6 records an ICMPv6 packet. The layer payload starts with the
+// four bytes following the checksum. vlan is the VLAN stack key used to find
+// TCP flows referenced by errors.
+func (d *Data) CaptureICMPv6(ip *layers.IPv6, icmp *layers.ICMPv6, ecn ECN,
+	vlan uint32, tstamp time.Time, flowIndex *int) {
+	d.ICMP.Packets++
+	d.ICMP.ECN.Add(ecn)
+
+	b := icmp.LayerPayload()
+	if len(b) < 4 {
+		return
+	}
+	id := binary.BigEndian.Uint16(b[0:2])
+	seq := binary.BigEndian.Uint16(b[2:4])
+
+	switch icmp.TypeCode.Type() {
+	case layers.ICMPv6TypeEchoRequest:
+		d.echoRequest(ip.SrcIP, ip.DstIP, id, seq, ecn, tstamp, flowIndex)
+	case layers.ICMPv6TypeEchoReply:
+		d.echoReply(ip.SrcIP, ip.DstIP, id, seq, ecn, tstamp)
+	case layers.ICMPv6TypeDestinationUnreachable:
+		d.ICMP.DestinationUnreachable++
+		d.icmpError(embeddedTCPFlowIPv6(d, b[4:], vlan), false, 0)
+	case layers.ICMPv6TypePacketTooBig:
+		d.ICMP.PacketTooBig++
+		d.icmpError(embeddedTCPFlowIPv6(d, b[4:], vlan), true, binary.BigEndian.Uint32(b[0:4]))
+	}
+}
+
+func (d *Data) echoRequest(src, dst net.IP, id, seq uint16, ecn ECN,
+	tstamp time.Time, flowIndex *int) {
+	var k EchoFlowKey
+	copy(k.SrcIP[:], src.To16())
+	copy(k.DstIP[:], dst.To16())
+	k.ID = id
+	f, ok := d.ICMP.Echo[k]
+	if !ok {
+		f = &EchoFlowData{
+			Index:     *flowIndex,
+			SrcIP:     src,
+			DstIP:     dst,
+			ID:        id,
+			SentTimes: make(map[uint16]time.Time),
+		}
+		d.ICMP.Echo[k] = f
+		*flowIndex++
+	}
+	f.Requests++
+	f.RequestECN.Add(ecn)
+	f.SentTimes[seq] = tstamp
+}
+
+func (d *Data) echoReply(src, dst net.IP, id, seq uint16, ecn ECN,
+	tstamp time.Time) {
+	var k EchoFlowKey
+	copy(k.SrcIP[:], dst.To16())
+	copy(k.DstIP[:], src.To16())
+	k.ID = id
+	f, ok := d.ICMP.Echo[k]
+	if !ok {
+		return
+	}
+	f.Replies++
+	f.ReplyECN.Add(ecn)
+	if pt, ok := f.SentTimes[seq]; ok {
+		f.RTT.Push(tstamp.Sub(pt))
+		delete(f.SentTimes, seq)
+	}
+}
+
+// icmpError records an error referencing the TCP flow f, which may be nil.
+// For packet too big errors, mtu is the reported MTU, or 0 if none.
+func (d *Data) icmpError(f *TCPFlowData, tooBig bool, mtu uint32) {
+	if f == nil {
+		d.ICMP.UnmatchedErrors++
+		return
+	}
+	if tooBig {
+		f.ICMPPacketTooBig++
+		if mtu > 0 && (f.ICMPMinMTU == 0 || mtu < f.ICMPMinMTU) {
+			f.ICMPMinMTU = mtu
+		}
+	} else {
+		f.ICMPDestinationUnreachable++
+	}
+}
+
+// embeddedTCPFlowIPv4 returns the tracked TCP flow for the IPv4 header and
+// leading TCP bytes embedded in an ICMP error, or nil if there is none.
+func embeddedTCPFlowIPv4(d *Data, b []byte, vlan uint32) *TCPFlowData {
+	if len(b) < 20 || b[0]>>4 != 4 || layers.IPProtocol(b[9]) != layers.IPProtocolTCP {
+		return nil
+	}
+	ihl := int(b[0]&0x0f) * 4
+	if len(b) < ihl+4 {
+		return nil
+	}
+	k := TCP4FlowKey{VLAN: vlan}
+	copy(k.SrcIP[:], b[12:16])
+	copy(k.DstIP[:], b[16:20])
+	k.SrcPort = layers.TCPPort(binary.BigEndian.Uint16(b[ihl : ihl+2]))
+	k.DstPort = layers.TCPPort(binary.BigEndian.Uint16(b[ihl+2 : ihl+4]))
+	if f, ok := d.TCP4[k]; ok {
+		return f
+	}
+	return d.TCP4[k.Reverse()]
+}
+
+// embeddedTCPFlowIPv6 returns the tracked TCP flow for the IPv6 header and
+// leading TCP bytes embedded in an ICMPv6 error, or nil if there is none.
+// Extension headers are not supported.
+func embeddedTCPFlowIPv6(d *Data, b []byte, vlan uint32) *TCPFlowData {
+	if len(b) < 44 || b[0]>>4 != 6 || layers.IPProtocol(b[6]) != layers.IPProtocolTCP {
+		return nil
+	}
+	k := TCP6FlowKey{VLAN: vlan}
+	copy(k.SrcIP[:], b[8:24])
+	copy(k.DstIP[:], b[24:40])
+	k.SrcPort = layers.TCPPort(binary.BigEndian.Uint16(b[40:42]))
+	k.DstPort = layers.TCPPort(binary.BigEndian.Uint16(b[42:44]))
+	if f, ok := d.TCP6[k]; ok {
+		return f
+	}
+	return d.TCP6[k.Reverse()]
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTCPFlowICMPJSON(t *testing.T) {
+	tests := []struct {
+		name            string
+		unreach, tooBig uint64
+		mtu             uint32
+		keys            []string
+	}{
+		{"none", 0, 0, 0, nil},
+		{"unreachable", 1, 0, 0, []string{"ICMPDestinationUnreachable"}},
+		{"packet too big", 0, 2, 1280, []string{"ICMPPacketTooBig",
+			"ICMPMinMTU"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewTCPFlowData(0, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2},
+				40000, 5201, time.Unix(0, 0))
+			f.ICMPDestinationUnreachable = tt.unreach
+			f.ICMPPacketTooBig = tt.tooBig
+			f.ICMPMinMTU = tt.mtu
+			b, err := json.Marshal(f)
+			if err != nil {
+				t.Fatalf("unable to marshal (%s)", err)
+			}
+			if n, k := strings.Count(string(b), `"ICMP`), len(tt.keys); n != k {
+				t.Errorf("%d ICMP keys in %s, want %d", n, b, k)
+			}
+			for _, k := range tt.keys {
+				if !strings.Contains(string(b), `"`+k+`":`) {
+					t.Errorf("key %s missing from %s", k, b)
+				}
+			}
+		})
+	}
+}
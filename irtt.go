@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// IRTT packets start with a three byte magic, followed by a flags byte, an
+// optional 16 byte HMAC, an 8 byte connection token and a 4 byte seqno. Replies
+// may be followed by the server's received stats and timestamps, according to
+// the params negotiated when the connection was opened. Values are little
+// endian.
+var irttMagic = []byte{0x14, 0xa7, 0x5b}
+
+// IRTT flags.
+const (
+	irttFlOpen = 1 << iota
+	irttFlReply
+	irttFlClose
+)
+
+const irttHMACLen = 16
+
+// IRTT param keys, as encoded in the open request and reply.
+const (
+	irttParamProtocolVersion = iota + 1
+	irttParamDuration
+	irttParamInterval
+	irttParamLength
+	irttParamReceivedStats
+	irttParamStampAt
+	irttParamClock
+	irttParamDSCP
+	irttParamServerFill
+)
+
+// IRTT param values that determine the reply layout.
+const (
+	irttReceivedCount  = 1
+	irttReceivedWindow = 2
+	irttAtSend         = 1
+	irttAtReceive      = 2
+	irttAtMidpoint     = 4
+	irttClockWall      = 1
+	irttClockMonotonic = 2
+)
+
+// IRTTParams contains the negotiated params that determine the reply layout.
+type IRTTParams struct {
+	ReceivedStats int
+	StampAt       int
+	Clock         int
+}
+
+// IRTTFlowData contains the information for one IRTT session. Src is the
+// client and Dst the server. Counts include only test packets, not those that
+// open or close the session.
+//
+// SendDelay and ReceiveDelay are only available when the server's wall clock
+// timestamps are in the replies and the open exchange was captured, and
+// assume the capture is taken at the client with clocks synchronized. IPDV is
+// the absolute difference in RTT between replies with consecutive seqnos.
+type IRTTFlowData struct {
+	Index            int `json:"-"`
+	SrcIP            net.IP
+	SrcPort          layers.UDPPort
+	DstIP            net.IP
+	DstPort          layers.UDPPort
+	Params           *IRTTParams `json:",omitempty"`
+	Requests         uint64
+	Replies          uint64
+	DuplicateReplies uint64
+	LateReplies      uint64
+	ServerReceived   uint64 `json:",omitempty"`
+	RequestECN       ECNCounters
+	ReplyECN         ECNCounters
+	RTT              DurationData
+	SendDelay        DurationData
+	ReceiveDelay     DurationData
+	IPDV             DurationData
+	SentTimes        map[uint32]time.Time `json:"-"`
+	hmacLen          int
+	layoutKnown      bool
+	ref              []byte
+	hiSeqno          uint32
+	replied          bool
+	priorSeqno       uint32
+	priorRTT         time.Duration
+	priorRTTValid    bool
+}
+
+// CaptureIRTT records a UDP packet in the IRTT flow table if it appears to be
+// IRTT, returning false if it does not. The Index for a new flow is taken from
+// flowIndex, which is then incremented.
+//
+// The HMAC's presence is learned either from the params in the open exchange,
+// or by finding the connection token at the same offset in two packets, and
+// seqnos are not tracked until then.
+func (d *Data) CaptureIRTT(k UDPFlowKey, srcIP, dstIP net.IP, payload []byte,
+	ecn ECN, tstamp time.Time, flowIndex *int) bool {
+	if len(payload) < 4 || !bytes.Equal(payload[:3], irttMagic) ||
+		payload[3]&^(irttFlOpen|irttFlReply|irttFlClose) != 0 {
+		return false
+	}
+	fl := payload[3]
+	reply := fl&irttFlReply != 0
+
+	// flows are keyed by the client to server 4-tuple
+	fk := k
+	if reply {
+		fk = k.Reverse()
+	}
+	f, ok := d.IRTT[fk]
+	if !ok {
+		if reply {
+			return true
+		}
+		f = &IRTTFlowData{
+			Index:     *flowIndex,
+			SrcIP:     srcIP,
+			SrcPort:   k.SrcPort,
+			DstIP:     dstIP,
+			DstPort:   k.DstPort,
+			SentTimes: make(map[uint32]time.Time),
+		}
+		d.IRTT[fk] = f
+		*flowIndex++
+	}
+
+	// the open request has no token and the open reply may carry params
+	if fl&irttFlOpen != 0 {
+		if reply && f.Params == nil {
+			if p, h, ok := findIRTTParams(payload); ok {
+				f.Params = &p
+				f.hmacLen = h
+				f.layoutKnown = true
+			} else {
+				f.learnLayout(payload)
+			}
+		}
+		return true
+	}
+	if fl&irttFlClose != 0 {
+		return true
+	}
+	if reply {
+		f.Replies++
+		f.ReplyECN.Add(ecn)
+	} else {
+		f.Requests++
+		f.RequestECN.Add(ecn)
+	}
+	if !f.layoutKnown {
+		f.learnLayout(payload)
+		return true
+	}
+
+	so := 4 + f.hmacLen + 8
+	if len(payload) < so+4 {
+		return true
+	}
+	seqno := binary.LittleEndian.Uint32(payload[so:])
+
+	if !reply {
+		f.SentTimes[seqno] = tstamp
+		return true
+	}
+
+	// handle reply
+	if f.replied && seqno-f.hiSeqno > 1<<31 {
+		f.LateReplies++
+	} else {
+		f.hiSeqno = seqno
+	}
+	f.replied = true
+	pt, ok := f.SentTimes[seqno]
+	if !ok {
+		f.DuplicateReplies++
+		return true
+	}
+	delete(f.SentTimes, seqno)
+	rtt := tstamp.Sub(pt)
+	f.RTT.Push(rtt)
+	if f.priorRTTValid && seqno == f.priorSeqno+1 {
+		ipdv := rtt - f.priorRTT
+		if ipdv < 0 {
+			ipdv = -ipdv
+		}
+		f.IPDV.Push(ipdv)
+	}
+	f.priorSeqno = seqno
+	f.priorRTT = rtt
+	f.priorRTTValid = true
+
+	if f.Params != nil {
+		f.readReplyFields(payload[so+4:], pt, tstamp)
+	}
+
+	return true
+}
+
+// learnLayout determines whether packets carry an HMAC by comparing the
+// connection token offsets in b with those in the first packet seen.
+func (f *IRTTFlowData) learnLayout(b []byte) {
+	if len(b) < 4+irttHMACLen+8 {
+		return
+	}
+	if f.ref == nil {
+		f.ref = append([]byte(nil), b[:4+irttHMACLen+8]...)
+		return
+	}
+	if bytes.Equal(b[4:12], f.ref[4:12]) {
+		f.hmacLen = 0
+		f.layoutKnown = true
+	} else if bytes.Equal(b[4+irttHMACLen:4+irttHMACLen+8], f.ref[4+irttHMACLen:]) {
+		f.hmacLen = irttHMACLen
+		f.layoutKnown = true
+	}
+	if f.layoutKnown {
+		f.ref = nil
+	}
+}
+
+// readReplyFields reads the received stats and timestamps in a reply, where b
+// starts after the seqno, st is the capture time of the request and rt the
+// capture time of the reply.
+func (f *IRTTFlowData) readReplyFields(b []byte, st, rt time.Time) {
+	p := f.Params
+	i := 0
+	if p.ReceivedStats&irttReceivedCount != 0 {
+		if len(b) < i+4 {
+			return
+		}
+		f.ServerReceived = uint64(binary.LittleEndian.Uint32(b[i:]))
+		i += 4
+	}
+	if p.ReceivedStats&irttReceivedWindow != 0 {
+		i += 8
+	}
+
+	// stamp reads the wall clock value from a timestamp, if present
+	stamp := func() (t time.Time, ok bool) {
+		if p.Clock&irttClockWall != 0 {
+			if len(b) < i+8 {
+				return
+			}
+			t = time.Unix(0, int64(binary.LittleEndian.Uint64(b[i:])))
+			ok = true
+			i += 8
+		}
+		if p.Clock&irttClockMonotonic != 0 {
+			i += 8
+		}
+		return
+	}
+
+	if p.StampAt == irttAtMidpoint {
+		if t, ok := stamp(); ok {
+			f.SendDelay.Push(t.Sub(st))
+			f.ReceiveDelay.Push(rt.Sub(t))
+		}
+		return
+	}
+	if p.StampAt&irttAtReceive != 0 {
+		if t, ok := stamp(); ok {
+			f.SendDelay.Push(t.Sub(st))
+		}
+	}
+	if p.StampAt&irttAtSend != 0 {
+		if t, ok := stamp(); ok {
+			f.ReceiveDelay.Push(rt.Sub(t))
+		}
+	}
+}
+
+// findIRTTParams finds and parses the params in an open reply, returning
+// the params and HMAC length. The params start with the protocol version,
+// and follow either the connection token or the seqno.
+func findIRTTParams(b []byte) (p IRTTParams, hmacLen int, ok bool) {
+	for _, h := range []int{0, irttHMACLen} {
+		for _, o := range []int{4 + h + 8, 4 + h + 8 + 4} {
+			if len(b) > o+1 && b[o] == irttParamProtocolVersion {
+				if p, ok = parseIRTTParams(b[o:]); ok {
+					hmacLen = h
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// parseIRTTParams parses params encoded as pairs of uvarint keys and varint
+// values, except for the server fill, which is a uvarint length prefixed
+// string.
+func parseIRTTParams(b []byte) (p IRTTParams, ok bool) {
+	for len(b) > 0 {
+		k, n := binary.Uvarint(b)
+		if n <= 0 {
+			return
+		}
+		b = b[n:]
+		if k == irttParamServerFill {
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return
+			}
+			b = b[n+int(l):]
+			continue
+		}
+		v, n := binary.Varint(b)
+		if n <= 0 {
+			return
+		}
+		b = b[n:]
+		switch k {
+		case irttParamReceivedStats:
+			p.ReceivedStats = int(v)
+		case irttParamStampAt:
+			p.StampAt = int(v)
+		case irttParamClock:
+			p.Clock = int(v)
+		case irttParamProtocolVersion, irttParamDuration, irttParamInterval,
+			irttParamLength, irttParamDSCP:
+		default:
+			return
+		}
+	}
+	ok = true
+	return
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseIRTTParams(t *testing.T) {
+	// keys are uvarints and values zigzag varints, so 1 encodes as 2
+	tests := []struct {
+		name string
+		b    []byte
+		ok   bool
+		p    IRTTParams
+	}{
+		{"empty", nil, true, IRTTParams{}},
+		{"layout", []byte{
+			irttParamProtocolVersion, 2,
+			irttParamReceivedStats, 2 * irttReceivedCount,
+			irttParamStampAt, 2 * irttAtMidpoint,
+			irttParamClock, 2 * irttClockWall,
+		}, true, IRTTParams{irttReceivedCount, irttAtMidpoint, irttClockWall}},
+		{"server fill", []byte{
+			irttParamServerFill, 2, 'a', 'b',
+			irttParamClock, 2 * irttClockMonotonic,
+		}, true, IRTTParams{Clock: irttClockMonotonic}},
+		{"missing value", []byte{irttParamClock}, false, IRTTParams{}},
+		{"truncated key", []byte{0x80}, false, IRTTParams{}},
+		{"truncated value", []byte{irttParamInterval, 0x80, 0x80}, false,
+			IRTTParams{}},
+		{"truncated server fill", []byte{irttParamServerFill, 3, 'a', 'b'},
+			false, IRTTParams{}},
+		{"missing server fill length", []byte{irttParamServerFill}, false,
+			IRTTParams{}},
+		{"unknown key", []byte{0x7f, 2}, false, IRTTParams{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := parseIRTTParams(tt.b)
+			if ok != tt.ok {
+				t.Fatalf("ok = %t, want %t", ok, tt.ok)
+			}
+			if ok && p != tt.p {
+				t.Errorf("params = %+v, want %+v", p, tt.p)
+			}
+		})
+	}
+}
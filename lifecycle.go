@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// Connection end states.
+const (
+	EndFIN        = "FIN"
+	EndHalfClosed = "HalfClosed"
+	EndRST        = "RST"
+	EndOpen       = "Open"
+)
+
+// TCPHandshakeData records the 3-way handshake for flows whose SYN was seen,
+// and the options negotiated in it.
+type TCPHandshakeData struct {
+	SYNTime        time.Time
+	SYNACKTime     time.Time
+	ACKTime        time.Time
+	SYNRetransmits uint64
+	// Complete is true once the initiator acked the SYN-ACK
+	Complete bool
+	// negotiated options, where MSS is the lower of the two offered, and the
+	// others require both sides to offer the option
+	MSS           uint16 `json:",omitempty"`
+	SACKPermitted bool
+	WindowScaling bool
+	Timestamps    bool
+	server        *TCPOneWayData
+}
+
+// synOptions records the options offered in a SYN or SYN-ACK in direction o.
+func synOptions(o *TCPOneWayData, tcp *layers.TCP) {
+	o.WindowScale = windowScale(tcp)
+	for _, opt := range tcp.Options {
+		switch opt.OptionType {
+		case layers.TCPOptionKindMSS:
+			if opt.OptionLength == 4 {
+				o.MSS = binary.BigEndian.Uint16(opt.OptionData)
+			}
+		case layers.TCPOptionKindSACKPermitted:
+			o.SACKPermitted = true
+		case layers.TCPOptionKindTimestamps:
+			o.Timestamps = true
+		}
+	}
+}
+
+// Handshake records the progress of the 3-way handshake for a segment sent in
+// direction to, after the options in any SYN were recorded.
+func (f *TCPFlowData) Handshake(tcp *layers.TCP, to, tor *TCPOneWayData,
+	tstamp time.Time) {
+	h := f.HandshakeData
+	switch {
+	case tcp.SYN && !tcp.ACK:
+		if h == nil {
+			f.HandshakeData = &TCPHandshakeData{SYNTime: tstamp}
+		} else if h.SYNACKTime.IsZero() {
+			h.SYNRetransmits++
+		}
+	case tcp.SYN && tcp.ACK:
+		if h == nil || !h.SYNACKTime.IsZero() {
+			return
+		}
+		h.SYNACKTime = tstamp
+		h.server = to
+		if to.MSS > 0 && tor.MSS > 0 {
+			h.MSS = to.MSS
+			if tor.MSS < h.MSS {
+				h.MSS = tor.MSS
+			}
+		}
+		h.SACKPermitted = to.SACKPermitted && tor.SACKPermitted
+		h.WindowScaling = to.WindowScale >= 0 && tor.WindowScale >= 0
+		h.Timestamps = to.Timestamps && tor.Timestamps
+	case tcp.ACK:
+		if h != nil && h.server == tor && !h.Complete {
+			h.ACKTime = tstamp
+			h.Complete = true
+		}
+	}
+}
+
+// Reused returns true if a segment sent in the Up direction (if up is true) or
+// Down direction is a SYN starting a new connection on the flow's 5-tuple.
+// This is the case if the flow ended with FIN or RST, if the SYN's ISN differs
+// from that of the direction's prior SYN, or if the direction sent segments
+// without a SYN being seen.
+func (f *TCPFlowData) Reused(tcp *layers.TCP, up bool) bool {
+	if !tcp.SYN || tcp.ACK {
+		return false
+	}
+	if f.RST != "" || f.Up.FinSeen || f.Down.FinSeen {
+		return true
+	}
+	o := f.Up
+	if !up {
+		o = f.Down
+	}
+	if o.SYNSeen {
+		return tcp.Seq != o.ISN
+	}
+	return o.Initialized
+}
+
+// Reset records a RST sent in the given direction ("Up" or "Down"). Only the
+// first RST is recorded.
+func (f *TCPFlowData) Reset(direction string) {
+	if f.RST == "" {
+		f.RST = direction
+	}
+}
+
+// TCPHandshakeResult adds the handshake RTTs, as seen from the capture point.
+// SYNRTTMillis is the RTT to the responder, ACKRTTMillis the RTT to the
+// initiator, and RTTMillis their sum.
+type TCPHandshakeResult struct {
+	*TCPHandshakeData
+	SYNRTTMillis float64
+	ACKRTTMillis float64
+	RTTMillis    float64
+}
+
+func NewTCPHandshakeResult(d *TCPHandshakeData) (r *TCPHandshakeResult) {
+	r = &TCPHandshakeResult{TCPHandshakeData: d}
+	if !d.SYNACKTime.IsZero() {
+		r.SYNRTTMillis = durToMs(d.SYNACKTime.Sub(d.SYNTime))
+	}
+	if d.Complete {
+		r.ACKRTTMillis = durToMs(d.ACKTime.Sub(d.SYNACKTime))
+		r.RTTMillis = durToMs(d.ACKTime.Sub(d.SYNTime))
+	}
+	return
+}
+
+// tcpEnd returns how a flow ended.
+func tcpEnd(d *TCPFlowData) string {
+	switch {
+	case d.RST != "":
+		return EndRST
+	case d.Up.FinSeen && d.Down.FinSeen:
+		return EndFIN
+	case d.Up.FinSeen || d.Down.FinSeen:
+		return EndHalfClosed
+	}
+	return EndOpen
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCaptureLifecycle(t *testing.T) {
+	type flow struct {
+		end            string
+		rst            string
+		synRetransmits uint64
+		complete       bool
+	}
+	ms := time.Millisecond
+	seg := func(s ...testSegment) []testSegment {
+		return append([]testSegment{}, s...)
+	}
+	rst := testSegment{at: 40 * ms, flags: "RA", seq: 5001, ack: 1301}
+	synRetx := testSegment{at: 5 * ms, up: true, flags: "S", seq: 1000}
+	reuse := seg(
+		testSegment{at: 100 * ms, up: true, flags: "S", seq: 9000},
+		testSegment{at: 110 * ms, flags: "SA", seq: 7000, ack: 9001},
+		testSegment{at: 120 * ms, up: true, flags: "A", seq: 9001, ack: 7001},
+	)
+	tests := []struct {
+		name  string
+		segs  []testSegment
+		flows []flow
+	}{
+		{"FIN", testTransfer, []flow{{EndFIN, "", 0, true}}},
+		{"half closed", testTransfer[:9], []flow{{EndHalfClosed, "", 0, true}}},
+		{"open", testTransfer[:8], []flow{{EndOpen, "", 0, true}}},
+		{"incomplete handshake", testTransfer[:2],
+			[]flow{{EndOpen, "", 0, false}}},
+		{"reset", append(seg(testTransfer[:8]...), rst),
+			[]flow{{EndRST, "Down", 0, true}}},
+		{"SYN retransmit", withSegments(testTransfer, 0, testTransfer[0],
+			synRetx), []flow{{EndFIN, "", 1, true}}},
+		{"reused after FIN", append(seg(testTransfer...), reuse...),
+			[]flow{{EndFIN, "", 0, true}, {EndOpen, "", 0, true}}},
+		{"reused after RST", append(append(seg(testTransfer[:8]...), rst),
+			reuse...),
+			[]flow{{EndRST, "Down", 0, true}, {EndOpen, "", 0, true}}},
+		{"RST for unknown flow", seg(rst), nil},
+	}
+	for _, v := range testIPVersions {
+		for _, tt := range tests {
+			t.Run(v.name+"/"+tt.name, func(t *testing.T) {
+				fs := testFlows(testCapture(t, tt.segs, v.ip6, testConfig()))
+				if len(fs) != len(tt.flows) {
+					t.Fatalf("%d flows, want %d", len(fs), len(tt.flows))
+				}
+				for i, f := range fs {
+					var g flow
+					g.end = tcpEnd(f)
+					g.rst = f.RST
+					if h := f.HandshakeData; h != nil {
+						g.synRetransmits = h.SYNRetransmits
+						g.complete = h.Complete
+					}
+					if g != tt.flows[i] {
+						t.Errorf("flow %d = %+v, want %+v", i, g, tt.flows[i])
+					}
+					if f.Up.DataSegments > 3 || f.Down.DataSegments > 0 {
+						t.Errorf("flow %d data segments %d up, %d down", i,
+							f.Up.DataSegments, f.Down.DataSegments)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// LinkTypeLinuxSLL2 is DLT_LINUX_SLL2 (276), as truncated by gopacket's 8-bit
+// LinkType.
+const LinkTypeLinuxSLL2 = layers.LinkType(276 % 256)
+
+// DLT_RAW values returned by pcap_datalink for raw IP captures (e.g. tun and
+// WireGuard interfaces), which differ from LINKTYPE_RAW (101) used in pcap
+// files. DLT_RAW is 12 on most platforms, including Linux, and 14 on OpenBSD.
+const (
+	LinkTypeDLTRaw        = layers.LinkType(12)
+	LinkTypeDLTRawOpenBSD = layers.LinkType(14)
+)
+
+// Layer types for the link layers not supported by gopacket.
+var (
+	LayerTypeLinuxSLL2 = gopacket.RegisterLayerType(2001, gopacket.LayerTypeMetadata{
+		Name:    "LinuxSLL2",
+		Decoder: gopacket.DecodeFunc(decodeLinuxSLL2),
+	})
+	LayerTypeRawIP = gopacket.RegisterLayerType(2002, gopacket.LayerTypeMetadata{
+		Name:    "RawIP",
+		Decoder: gopacket.DecodeFunc(decodeRawIP),
+	})
+)
+
+// FirstLayerType returns the layer type that decoding starts with for the
+// given link type.
+func FirstLayerType(lt layers.LinkType) (gopacket.LayerType, error) {
+	switch lt {
+	case layers.LinkTypeEthernet:
+		return layers.LayerTypeEthernet, nil
+	case layers.LinkTypeLinuxSLL:
+		return layers.LayerTypeLinuxSLL, nil
+	case LinkTypeLinuxSLL2:
+		return LayerTypeLinuxSLL2, nil
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		return layers.LayerTypeLoopback, nil
+	case layers.LinkTypeRaw, LinkTypeDLTRaw, LinkTypeDLTRawOpenBSD:
+		return LayerTypeRawIP, nil
+	case layers.LinkTypeIPv4:
+		return layers.LayerTypeIPv4, nil
+	case layers.LinkTypeIPv6:
+		return layers.LayerTypeIPv6, nil
+	}
+	return gopacket.LayerTypeZero, fmt.Errorf("unsupported link-type %s", lt)
+}
+
+// LinuxSLL2 is the Linux cooked capture v2 header, used by captures on the
+// "any" interface in newer versions of libpcap.
+type LinuxSLL2 struct {
+	layers.BaseLayer
+	EthernetType   layers.EthernetType
+	InterfaceIndex uint32
+	ARPHardware    uint16
+	PacketType     uint8
+	AddrLen        uint8
+	Addr           [8]byte
+}
+
+func (s *LinuxSLL2) LayerType() gopacket.LayerType {
+	return LayerTypeLinuxSLL2
+}
+
+func (s *LinuxSLL2) CanDecode() gopacket.LayerClass {
+	return LayerTypeLinuxSLL2
+}
+
+func (s *LinuxSLL2) NextLayerType() gopacket.LayerType {
+	return s.EthernetType.LayerType()
+}
+
+func (s *LinuxSLL2) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 20 {
+		return errors.New("Linux SLL2 packet too small")
+	}
+	s.EthernetType = layers.EthernetType(binary.BigEndian.Uint16(data[0:2]))
+	s.InterfaceIndex = binary.BigEndian.Uint32(data[4:8])
+	s.ARPHardware = binary.BigEndian.Uint16(data[8:10])
+	s.PacketType = data[10]
+	s.AddrLen = data[11]
+	copy(s.Addr[:], data[12:20])
+	s.BaseLayer = layers.BaseLayer{Contents: data[:20], Payload: data[20:]}
+	return nil
+}
+
+func decodeLinuxSLL2(data []byte, p gopacket.PacketBuilder) error {
+	s := &LinuxSLL2{}
+	if err := s.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(s)
+	return p.NextDecoder(s.NextLayerType())
+}
+
+// RawIP is an empty layer that precedes IPv4 or IPv6 packets with no link
+// layer header, and selects the next layer by the IP version.
+type RawIP struct {
+	layers.BaseLayer
+	Version uint8
+}
+
+func (r *RawIP) LayerType() gopacket.LayerType {
+	return LayerTypeRawIP
+}
+
+func (r *RawIP) CanDecode() gopacket.LayerClass {
+	return LayerTypeRawIP
+}
+
+func (r *RawIP) NextLayerType() gopacket.LayerType {
+	switch r.Version {
+	case 4:
+		return layers.LayerTypeIPv4
+	case 6:
+		return layers.LayerTypeIPv6
+	}
+	return gopacket.LayerTypePayload
+}
+
+func (r *RawIP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 1 {
+		return errors.New("raw IP packet empty")
+	}
+	r.Version = data[0] >> 4
+	r.BaseLayer = layers.BaseLayer{Contents: data[:0], Payload: data}
+	return nil
+}
+
+func decodeRawIP(data []byte, p gopacket.PacketBuilder) error {
+	r := &RawIP{}
+	if err := r.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(r)
+	return p.NextDecoder(r.NextLayerType())
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"log"
@@ -15,9 +16,19 @@ import (
 
 const DEFAULT_BUFFER_SIZE = 10 * 1024 * 1024
 
-const DEFAULT_SNAPLEN = 118 // Ethernet VLAN (18), IPv6 (40), TCP max header len (60)
+// DEFAULT_SNAPLEN covers unencapsulated packets, and a warning is logged if
+// deeper encapsulation truncates transport headers.
+const DEFAULT_SNAPLEN = 122 // Ethernet QinQ (22), IPv6 (40), TCP max header len (60)
 
-func run(pc *PCAP) {
+// OutputConfig contains the configuration for output of the results.
+type OutputConfig struct {
+	Format     string
+	Histograms string
+	CDFs       string
+	Groups     FlowGroups
+}
+
+func run(pc *PCAP, c *ParseConfig, o *OutputConfig) {
 	data := NewData()
 	pch := make(chan gopacket.Packet, 100000)
 
@@ -28,7 +39,24 @@ func run(pc *PCAP) {
 		}()
 		data.Meta.ParseEndTime = time.Now()
 		data.Meta.PCAPStats, _ = pc.Stats()
-		NewResult(data).Emit()
+		if f, ok := c.Events.(*bufio.Writer); ok {
+			if err := f.Flush(); err != nil {
+				log.Printf("unable to flush event log (%s)", err)
+			}
+		}
+		r := NewResult(data)
+		r.Group(o.Groups)
+		r.Emit(o.Format)
+		if o.Histograms != "" {
+			if err := r.WriteHistograms(o.Histograms); err != nil {
+				log.Println(err)
+			}
+		}
+		if o.CDFs != "" {
+			if err := r.WriteCDFs(o.CDFs); err != nil {
+				log.Println(err)
+			}
+		}
 	}
 
 	// Calling Close on the pcap Handle deadlocks on OS/X when there are no
@@ -47,7 +75,7 @@ func run(pc *PCAP) {
 
 	go pc.Drain(pch)
 
-	Capture(pch, data)
+	Capture(pch, data, c)
 	emit()
 }
 
@@ -55,7 +83,7 @@ func main() {
 	log.SetFlags(0)
 
 	flag.Usage = func() {
-		fmt.Printf("usage: %s [-r file] | [-i iface] [-s snaplen] [-b bufsize] [-t tstamp_type] [-p] [filter expression]\n", os.Args[0])
+		fmt.Printf("usage: %s [-r file] | [-i iface] [-s snaplen] [-b bufsize] [-t tstamp_type] [-p] [-m mode] [-V] [-w window] [-e eventfile] [-o format] [-a maxage] [-H histfile] [-c cdfprefix] [-q basertt_window] [-g group]... [filter expression]\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 
@@ -65,6 +93,17 @@ func main() {
 	b := flag.Int("b", DEFAULT_BUFFER_SIZE, "pcap buffer size")
 	t := flag.String("t", "", "timestamp source (see tcap-tstamp(7))")
 	p := flag.Bool("p", false, "disable promiscuous mode")
+	m := flag.String("m", "sce", "ECT(1) interpretation (sce or l4s)")
+	v := flag.Bool("V", false, "keep flows on different VLANs separate")
+	w := flag.Duration("w", 0, "window interval for time-series output (e.g. 100ms, 0 to disable)")
+	e := flag.String("e", "", "file to write per-segment NDJSON event log to")
+	o := flag.String("o", FormatJSON, "output format (json, text or csv)")
+	a := flag.Duration("a", DefaultMaxRTTAge, "max age of unacked segments and TSVals for RTT (0 for no limit)")
+	H := flag.String("H", "", "file to write CSV histograms of TCP stats to")
+	cdf := flag.String("c", "", "file name prefix for CSV CDFs of TCP RTT and IPG, one file per stat")
+	q := flag.Duration("q", DefaultBaseRTTWindow, "window for the minimum RTT used as the base RTT for queueing delay (0 for all time)")
+	var g FlowGroups
+	flag.Var(&g, "g", "TCP flow group as name=kind:value, with kind port, net, dscp or re (may be repeated)")
 	flag.Parse()
 
 	if *i != "" && *r != "" {
@@ -79,7 +118,37 @@ func main() {
 		os.Exit(1)
 	}
 
-	var err error
+	if *o != FormatJSON && *o != FormatText && *o != FormatCSV {
+		log.Printf("unknown output format \"%s\"", *o)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	mode, err := ParseECT1Mode(*m)
+	if err != nil {
+		log.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *w < 0 {
+		log.Println("window interval must not be negative")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *a < 0 {
+		log.Println("max RTT age must not be negative")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *q < 0 {
+		log.Println("base RTT window must not be negative")
+		flag.Usage()
+		os.Exit(1)
+	}
+
 	var pc *PCAP
 	if *i != "" {
 		if pc, err = OpenLive(&CaptureConfig{*i, *s, *b, false, *p, *t}); err != nil {
@@ -109,5 +178,25 @@ func main() {
 		}
 	}
 
-	run(pc)
+	fl, err := FirstLayerType(pc.Handle.LinkType())
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	c := &ParseConfig{ECT1Mode: mode, FirstLayer: fl, SplitVLANs: *v, Window: *w,
+		MaxRTTAge: *a, BaseRTTWindow: *q}
+	if *e != "" {
+		var f *os.File
+		if f, err = os.Create(*e); err != nil {
+			log.Printf("unable to create event log \"%s\" (%s)", *e, err)
+			os.Exit(1)
+		}
+		defer func() {
+			f.Close()
+		}()
+		c.Events = bufio.NewWriter(f)
+	}
+
+	run(pc, c, &OutputConfig{*o, *H, *cdf, g})
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"time"
+)
+
+// DefaultBaseRTTWindow is the default length of the sliding window over which
+// the minimum RTT is taken as the base RTT for queueing delay.
+const DefaultBaseRTTWindow = 10 * time.Second
+
+// MinFilter tracks the minimum of the duration samples within a sliding
+// window of time, using a monotonic queue so memory is bounded by the number of
+// samples in the window, and usually far less.
+type MinFilter struct {
+	samples []minSample
+	head    int
+}
+
+type minSample struct {
+	time time.Time
+	d    time.Duration
+}
+
+// Push adds sample d at time t, and returns the minimum of the samples within
+// window of t. A window of zero means the minimum of all samples.
+func (f *MinFilter) Push(t time.Time, d time.Duration,
+	window time.Duration) time.Duration {
+	for len(f.samples) > f.head && f.samples[len(f.samples)-1].d >= d {
+		f.samples = f.samples[:len(f.samples)-1]
+	}
+	f.samples = append(f.samples, minSample{t, d})
+	if window > 0 {
+		for t.Sub(f.samples[f.head].time) > window {
+			f.head++
+		}
+	}
+	if f.head > 0 && f.head >= len(f.samples)/2 {
+		n := copy(f.samples, f.samples[f.head:])
+		f.samples = f.samples[:n]
+		f.head = 0
+	}
+	return f.samples[f.head].d
+}
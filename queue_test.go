@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMinFilter(t *testing.T) {
+	type push struct {
+		at   time.Duration
+		d    time.Duration
+		want time.Duration
+	}
+	ms := time.Millisecond
+	tests := []struct {
+		name   string
+		window time.Duration
+		pushes []push
+	}{
+		{"expiry", 1500 * ms, []push{
+			{0, 10 * ms, 10 * ms},
+			{1000 * ms, 20 * ms, 10 * ms},
+			{2000 * ms, 30 * ms, 20 * ms},
+			{3000 * ms, 40 * ms, 30 * ms},
+		}},
+		{"at window", 1000 * ms, []push{
+			{0, 10 * ms, 10 * ms},
+			{1000 * ms, 20 * ms, 10 * ms},
+			{1001 * ms, 20 * ms, 20 * ms},
+		}},
+		{"new minimum", 1000 * ms, []push{
+			{0, 30 * ms, 30 * ms},
+			{100 * ms, 10 * ms, 10 * ms},
+			{200 * ms, 20 * ms, 10 * ms},
+			{1200 * ms, 25 * ms, 20 * ms},
+		}},
+		{"all expired", 100 * ms, []push{
+			{0, 10 * ms, 10 * ms},
+			{50 * ms, 20 * ms, 10 * ms},
+			{1000 * ms, 30 * ms, 30 * ms},
+		}},
+		{"no window", 0, []push{
+			{0, 10 * ms, 10 * ms},
+			{time.Hour, 20 * ms, 10 * ms},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f MinFilter
+			t0 := time.Unix(0, 0)
+			for i, p := range tt.pushes {
+				if m := f.Push(t0.Add(p.at), p.d, tt.window); m != p.want {
+					t.Errorf("push %d: min = %s, want %s", i, m, p.want)
+				}
+			}
+		})
+	}
+}
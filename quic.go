@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// QUICPort is the UDP port on which short header packets are accepted as QUIC
+// even if the connection's handshake was not seen.
+const QUICPort = 443
+
+// QUIC versions recognized in long headers, in addition to IETF drafts.
+const (
+	QUICVersion1 = 0x00000001
+	QUICVersion2 = 0x6b3343cf
+)
+
+// UDPFlowKey identifies a UDP flow by its 4-tuple, plus the VLAN stack key,
+// which is zero unless flows are split by VLAN. IPv4 addresses are stored in
+// their 16-byte form so that one key type serves both IP versions.
+type UDPFlowKey struct {
+	SrcIP   [16]byte
+	SrcPort layers.UDPPort
+	DstIP   [16]byte
+	DstPort layers.UDPPort
+	VLAN    uint32
+}
+
+func (k UDPFlowKey) Reverse() UDPFlowKey {
+	return UDPFlowKey{k.DstIP, k.DstPort, k.SrcIP, k.SrcPort, k.VLAN}
+}
+
+// QUICFlowKey identifies a QUIC connection by the 4-tuple of packets sent to
+// an endpoint, plus a connection ID chosen by that endpoint.
+type QUICFlowKey struct {
+	UDPFlowKey
+	CID string
+}
+
+type QUICOneWayData struct {
+	Packets            uint64
+	Bytes              uint64
+	LongHeaderPackets  uint64
+	ShortHeaderPackets uint64
+	ECN                ECNCounters
+	IPG                DurationData
+	PriorPacketTime    time.Time `json:"-"`
+}
+
+// QUICFlowData contains the information for one QUIC connection. Up is the
+// direction of the first packet seen, normally from the client.
+type QUICFlowData struct {
+	Index   int `json:"-"`
+	SrcIP   net.IP
+	SrcPort layers.UDPPort
+	DstIP   net.IP
+	DstPort layers.UDPPort
+	Version uint32   `json:",omitempty"`
+	CIDs    []string `json:",omitempty"`
+	Up      *QUICOneWayData
+	Down    *QUICOneWayData
+	key     UDPFlowKey
+}
+
+// quicHeader contains the fields parsed from a QUIC long header.
+type quicHeader struct {
+	version uint32
+	dcid    []byte
+	scid    []byte
+}
+
+// parseQUICLongHeader parses the invariant fields of a QUIC long header,
+// returning false if b is not a long header packet for a known version.
+func parseQUICLongHeader(b []byte) (h quicHeader, ok bool) {
+	if len(b) < 7 || b[0]&0xc0 != 0xc0 {
+		return
+	}
+	h.version = binary.BigEndian.Uint32(b[1:5])
+	if !quicVersionKnown(h.version) {
+		return
+	}
+	i := 5
+	n := int(b[i])
+	if n > 20 || len(b) < i+1+n+1 {
+		return
+	}
+	h.dcid = b[i+1 : i+1+n]
+	i += 1 + n
+	n = int(b[i])
+	if n > 20 || len(b) < i+1+n {
+		return
+	}
+	h.scid = b[i+1 : i+1+n]
+	ok = true
+	return
+}
+
+func quicVersionKnown(v uint32) bool {
+	return v == QUICVersion1 || v == QUICVersion2 || v&0xffffff00 == 0xff000000
+}
+
+// CaptureQUIC records a UDP packet in the QUIC flow table if it appears to be
+// QUIC, returning false if it does not. The Index for a new flow is taken from
+// flowIndex, which is then incremented.
+//
+// Connection IDs are learned from long headers, and short header packets are
+// matched using the lengths of the IDs learned for the 4-tuple. Short header
+// packets for connections whose handshake was not seen are only accepted on
+// QUICPort, and are tracked by 4-tuple alone.
+func (d *Data) CaptureQUIC(k UDPFlowKey, srcIP, dstIP net.IP, payload []byte,
+	ecn ECN, tstamp time.Time, flowIndex *int) bool {
+	if len(payload) == 0 || payload[0]&0x40 == 0 {
+		return false
+	}
+
+	newFlow := func(cid []byte) *QUICFlowData {
+		f := &QUICFlowData{
+			Index:   *flowIndex,
+			SrcIP:   srcIP,
+			SrcPort: k.SrcPort,
+			DstIP:   dstIP,
+			DstPort: k.DstPort,
+			Up:      &QUICOneWayData{},
+			Down:    &QUICOneWayData{},
+			key:     k,
+		}
+		*flowIndex++
+		d.addQUICCID(k, cid, f)
+		return f
+	}
+
+	var f *QUICFlowData
+	h, long := parseQUICLongHeader(payload)
+	if long {
+		if f = d.QUIC[QUICFlowKey{k, string(h.dcid)}]; f == nil {
+			f = newFlow(h.dcid)
+		}
+		d.addQUICCID(k.Reverse(), h.scid, f)
+		if f.Version == 0 {
+			f.Version = h.version
+		}
+	} else if payload[0]&0x80 != 0 {
+		return false
+	} else {
+		for _, n := range d.QUICCIDLens[k] {
+			if len(payload) > n {
+				if f = d.QUIC[QUICFlowKey{k, string(payload[1 : 1+n])}]; f != nil {
+					break
+				}
+			}
+		}
+		if f == nil {
+			if k.SrcPort != QUICPort && k.DstPort != QUICPort {
+				return false
+			}
+			f = newFlow(nil)
+			d.addQUICCID(k.Reverse(), nil, f)
+		}
+	}
+
+	o := f.Up
+	if k != f.key {
+		o = f.Down
+	}
+	o.Packets++
+	o.Bytes += uint64(len(payload))
+	if long {
+		o.LongHeaderPackets++
+	} else {
+		o.ShortHeaderPackets++
+	}
+	o.ECN.Add(ecn)
+	if !o.PriorPacketTime.IsZero() {
+		o.IPG.Push(tstamp.Sub(o.PriorPacketTime))
+	}
+	o.PriorPacketTime = tstamp
+
+	return true
+}
+
+// addQUICCID adds a connection ID for packets sent on the 4-tuple k.
+func (d *Data) addQUICCID(k UDPFlowKey, cid []byte, f *QUICFlowData) {
+	qk := QUICFlowKey{k, string(cid)}
+	if _, ok := d.QUIC[qk]; ok {
+		return
+	}
+	d.QUIC[qk] = f
+	if len(cid) > 0 {
+		f.CIDs = append(f.CIDs, hex.EncodeToString(cid))
+	}
+	for _, n := range d.QUICCIDLens[k] {
+		if n == len(cid) {
+			return
+		}
+	}
+	d.QUICCIDLens[k] = append(d.QUICCIDLens[k], len(cid))
+}
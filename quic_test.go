@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseQUICLongHeader(t *testing.T) {
+	// long header, version 1, 4 byte DCID, 2 byte SCID
+	p := []byte{0xc3, 0x00, 0x00, 0x00, 0x01, 4, 1, 2, 3, 4, 2, 5, 6}
+	tests := []struct {
+		name string
+		b    []byte
+		ok   bool
+		dcid []byte
+		scid []byte
+	}{
+		{"complete", p, true, []byte{1, 2, 3, 4}, []byte{5, 6}},
+		{"trailing bytes", append(append([]byte{}, p...), 0xff, 0xff), true,
+			[]byte{1, 2, 3, 4}, []byte{5, 6}},
+		{"empty CIDs", []byte{0xc0, 0x00, 0x00, 0x00, 0x01, 0, 0}, true,
+			[]byte{}, []byte{}},
+		{"empty", nil, false, nil, nil},
+		{"truncated version", p[:4], false, nil, nil},
+		{"truncated DCID", p[:8], false, nil, nil},
+		{"missing SCID length", p[:10], false, nil, nil},
+		{"truncated SCID", p[:12], false, nil, nil},
+		{"short header", append([]byte{0x43}, p[1:]...), false, nil, nil},
+		{"unknown version", []byte{0xc0, 0x12, 0x34, 0x56, 0x78, 0, 0}, false,
+			nil, nil},
+		{"DCID too long", append([]byte{0xc0, 0x00, 0x00, 0x00, 0x01, 21},
+			make([]byte, 22)...), false, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, ok := parseQUICLongHeader(tt.b)
+			if ok != tt.ok {
+				t.Fatalf("ok = %t, want %t", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if h.version != QUICVersion1 {
+				t.Errorf("version = %#x, want %#x", h.version, QUICVersion1)
+			}
+			if !bytes.Equal(h.dcid, tt.dcid) {
+				t.Errorf("dcid = %x, want %x", h.dcid, tt.dcid)
+			}
+			if !bytes.Equal(h.scid, tt.scid) {
+				t.Errorf("scid = %x, want %x", h.scid, tt.scid)
+			}
+		})
+	}
+}
@@ -1,73 +1,241 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"sort"
 	"time"
 )
 
+// Output formats supported by Emit.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+	FormatCSV  = "csv"
+)
+
 // Result contains Data plus post-processed statistics.
 type Result struct {
 	*Data
 	TCP  []*TCPFlowResult
-	Meta MetaResult
+	QUIC []*QUICFlowResult `json:",omitempty"`
+	Echo []*EchoFlowResult `json:",omitempty"`
+	IRTT []*IRTTFlowResult `json:",omitempty"`
+	// Groups contains the aggregate results for TCP flow groups
+	Groups []*TCPGroupResult `json:",omitempty"`
+	// Fairness is the fairness of goodput across TCP flows
+	Fairness *FairnessResult
+	Meta     MetaResult
 }
 
 func NewResult(d *Data) (r *Result) {
 	r = &Result{
 		Data: d,
-		TCP:  make([]*TCPFlowResult, 0, len(d.TCP4)+len(d.TCP6)),
+		TCP:  make([]*TCPFlowResult, 0, len(d.TCP4)+len(d.TCP6)+len(d.TCPClosed)),
 	}
 
 	for _, fd := range r.TCP4 {
-		r.TCP = append(r.TCP, NewTCPFlowResult(fd))
+		r.TCP = append(r.TCP, NewTCPFlowResult(fd, d.Meta.ECT1Mode))
 	}
 	for _, fd := range r.TCP6 {
-		r.TCP = append(r.TCP, NewTCPFlowResult(fd))
+		r.TCP = append(r.TCP, NewTCPFlowResult(fd, d.Meta.ECT1Mode))
+	}
+	for _, fd := range r.TCPClosed {
+		r.TCP = append(r.TCP, NewTCPFlowResult(fd, d.Meta.ECT1Mode))
 	}
 	sort.Slice(r.TCP, func(i, j int) bool { return r.TCP[i].Index < r.TCP[j].Index })
+	r.Fairness = NewFairnessResult(r.TCP, d.Meta.CaptureStartTime)
+
+	// QUIC flows appear once for each connection ID, so skip duplicates
+	qs := make(map[*QUICFlowData]bool)
+	for _, fd := range r.Data.QUIC {
+		if !qs[fd] {
+			qs[fd] = true
+			r.QUIC = append(r.QUIC, NewQUICFlowResult(fd))
+		}
+	}
+	sort.Slice(r.QUIC, func(i, j int) bool { return r.QUIC[i].Index < r.QUIC[j].Index })
+
+	for _, fd := range r.ICMP.Echo {
+		r.Echo = append(r.Echo, NewEchoFlowResult(fd))
+	}
+	sort.Slice(r.Echo, func(i, j int) bool { return r.Echo[i].Index < r.Echo[j].Index })
+
+	for _, fd := range r.Data.IRTT {
+		r.IRTT = append(r.IRTT, NewIRTTFlowResult(fd))
+	}
+	sort.Slice(r.IRTT, func(i, j int) bool { return r.IRTT[i].Index < r.IRTT[j].Index })
 
 	r.Meta = NewMetaResult(d.Meta, d.IP)
 
 	return
 }
 
-func (r *Result) Emit() {
-	json, err := json.MarshalIndent(r, "", "    ")
+// Emit writes the results to stdout in the given format, and a summary to
+// the log.
+func (r *Result) Emit(format string) {
+	var err error
+	switch format {
+	case FormatText:
+		err = r.EmitText(os.Stdout)
+	case FormatCSV:
+		err = r.EmitCSV(os.Stdout)
+	default:
+		err = r.EmitJSON(os.Stdout)
+	}
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	fmt.Println(string(json))
-
 	if r.Meta.PCAPStats != nil {
-		log.Printf("%d packets with %d TCP flows captured at %.0f pps",
-			r.IP.Packets, len(r.TCP), r.Meta.CapturePacketsPerSecond)
+		log.Printf("%d packets with %d TCP and %d QUIC flows captured at %.0f pps",
+			r.IP.Packets, len(r.TCP), len(r.QUIC), r.Meta.CapturePacketsPerSecond)
 		log.Printf("%d packets received by filter", r.Meta.PCAPStats.PacketsReceived)
 		log.Printf("%d packets dropped by kernel", r.Meta.PCAPStats.PacketsDropped)
 		log.Printf("%d packets dropped by interface", r.Meta.PCAPStats.PacketsIfDropped)
 	} else {
-		log.Printf("%d packets with %d TCP flows parsed at %.0f pps (%.2fMbit)",
-			r.IP.Packets, len(r.TCP), r.Meta.ParsePacketsPerSecond, r.Meta.ParseMbit)
+		log.Printf("%d packets with %d TCP and %d QUIC flows parsed at %.0f pps (%.2fMbit)",
+			r.IP.Packets, len(r.TCP), len(r.QUIC), r.Meta.ParsePacketsPerSecond, r.Meta.ParseMbit)
+	}
+}
+
+// MarshalJSON marshals the results with field names according to the ECT(1)
+// mode, as given by FieldName.
+func (r *Result) MarshalJSON() ([]byte, error) {
+	type result Result
+	b, err := json.Marshal((*result)(r))
+	if err != nil || r.Meta.ECT1Mode != ModeL4S {
+		return b, err
+	}
+	return renameKeys(b, r.Meta.ECT1Mode.FieldName)
+}
+
+// renameKeys returns the JSON in b with each object key k replaced by
+// rename(k). String values are not changed.
+func renameKeys(b []byte, rename func(string) string) ([]byte, error) {
+	type container struct {
+		object bool
+		n      int
+		key    bool
+	}
+	var stack []*container
+	var out bytes.Buffer
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	for {
+		t, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var c *container
+		if len(stack) > 0 {
+			c = stack[len(stack)-1]
+		}
+
+		// write separator
+		if d, ok := t.(json.Delim); c != nil && (!ok || d == '{' || d == '[') {
+			switch {
+			case c.object && !c.key:
+				out.WriteByte(':')
+			case c.n > 0:
+				out.WriteByte(',')
+			}
+		}
+
+		// write token
+		var v []byte
+		switch t := t.(type) {
+		case json.Delim:
+			out.WriteRune(rune(t))
+			if t == '{' || t == '[' {
+				stack = append(stack, &container{object: t == '{', key: true})
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				continue
+			}
+			c = stack[len(stack)-1]
+		case string:
+			if c != nil && c.object && c.key {
+				t = rename(t)
+			}
+			v, err = json.Marshal(t)
+		case nil:
+			v = []byte("null")
+		default:
+			v, err = json.Marshal(t)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out.Write(v)
+
+		// advance container state
+		if c != nil {
+			if c.object && c.key {
+				c.key = false
+				continue
+			}
+			c.key = true
+			c.n++
+		}
 	}
+	return out.Bytes(), nil
+}
+
+// EmitJSON writes the results as indented JSON.
+func (r *Result) EmitJSON(w io.Writer) error {
+	json, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(json))
+	return err
 }
 
 type TCPFlowResult struct {
 	*TCPFlowData
+	Handshake          *TCPHandshakeResult `json:",omitempty"`
+	End                string
+	DurationSeconds    float64
 	Up                 *TCPOneWayResult
 	Down               *TCPOneWayResult
 	MeanSeqRTTMillis   float64
 	MeanTSValRTTMillis float64
+	// AckedBytesSharePercent is the flow's share of the acked bytes for all
+	// TCP flows
+	AckedBytesSharePercent float64
 }
 
-func NewTCPFlowResult(d *TCPFlowData) (r *TCPFlowResult) {
+func NewTCPFlowResult(d *TCPFlowData, mode ECT1Mode) (r *TCPFlowResult) {
 	r = &TCPFlowResult{
 		TCPFlowData: d,
 		Up:          NewTCPOneWayResult(d.Up, d.Down),
 		Down:        NewTCPOneWayResult(d.Down, d.Up),
 	}
+	r.Up.TunnelECN = &ModeECNMatrix{&d.Up.TunnelECN, mode}
+	r.Down.TunnelECN = &ModeECNMatrix{&d.Down.TunnelECN, mode}
+	if d.HandshakeData != nil {
+		r.Handshake = NewTCPHandshakeResult(d.HandshakeData)
+	}
+	r.End = tcpEnd(d)
+	r.DurationSeconds = d.LastTime.Sub(d.FirstTime).Seconds()
+
+	if mode == ModeL4S {
+		r.Up.Class = l4sClass(d.Up)
+		r.Down.Class = l4sClass(d.Down)
+	}
+	r.Up.ECNAnomalies = d.Up.ECNTracking.Anomalies(mode)
+	r.Down.ECNAnomalies = d.Down.ECNTracking.Anomalies(mode)
 
 	// update some inter-dependent stats after creation
 	updateOWR := func(o *TCPOneWayResult, or *TCPOneWayResult) {
@@ -97,11 +265,55 @@ type TCPOneWayResult struct {
 	MeanGapSizeBytes      float64
 	MeanSegmentSizeBytes  float64
 	GoodputMbit           float64
+	SCEEchoedPercent      float64
+	// ReceiverLimited is the time the sender's flight was within one
+	// segment of the receive window, and its percent of the reverse
+	// direction's elapsed ack time
+	ReceiverLimitedSeconds float64
+	ReceiverLimitedPercent float64
+	// RTT samples discarded because they were covered by a later ack or
+	// TSecr, or exceeded the maximum age
+	SeqRTTDiscarded   uint64
+	TSValRTTDiscarded uint64
+	AccECN            *AccECNResult `json:",omitempty"`
+	// TunnelECN names ECT(1) according to the mode
+	TunnelECN *ModeECNMatrix
+	// Class is L4S, Classic or NotECT by the sender's codepoint, in L4S mode
+	Class        string   `json:",omitempty"`
+	ECNAnomalies []string `json:",omitempty"`
+}
+
+// l4sClass classifies a sender as L4S or classic by the codepoint most used
+// on its data segments, excluding CE.
+func l4sClass(d *TCPOneWayData) string {
+	switch {
+	case d.DataECN.SCE > 0 && d.DataECN.SCE >= d.DataECN.ECT0:
+		return "L4S"
+	case d.DataECN.ECT0 > 0:
+		return "Classic"
+	case d.DataSegments > 0:
+		return "NotECT"
+	}
+	return ""
+}
+
+// AccECNResult compares the AccECN feedback sent in one direction with what
+// was observed on the wire in the other direction, at the capture point.
+type AccECNResult struct {
+	*AccECNData
+	ObservedCEPackets uint64
+	ObservedECT0Bytes uint64
+	ObservedCEBytes   uint64
+	ObservedECT1Bytes uint64
 }
 
 func NewTCPOneWayResult(d *TCPOneWayData, dr *TCPOneWayData) (r *TCPOneWayResult) {
 	r = &TCPOneWayResult{TCPOneWayData: d}
 
+	if n := len(r.Windows); n > 0 {
+		r.Windows[n-1].Close(r.TCPCounters)
+	}
+
 	r.ElapsedAckTimeSeconds = r.LastAckTime.Sub(r.FirstAckTime).Seconds()
 	if r.DataSegments > 0 {
 		r.SCEPercent = 100 * float64(r.SCE) / float64(r.DataSegments)
@@ -130,6 +342,94 @@ func NewTCPOneWayResult(d *TCPOneWayData, dr *TCPOneWayData) (r *TCPOneWayResult
 	if r.DataSegments > 0 {
 		r.MeanSegmentSizeBytes = float64(dr.AckedBytes) / float64(r.DataSegments)
 	}
+	r.ReceiverLimitedSeconds = r.ReceiverLimited.Seconds()
+	r.SeqRTTDiscarded = d.SeqTimes.Discarded
+	r.TSValRTTDiscarded = d.TSValTimes.Discarded
+	if e := dr.LastAckTime.Sub(dr.FirstAckTime); e > 0 {
+		r.ReceiverLimitedPercent = 100 * float64(r.ReceiverLimited) / float64(e)
+	}
+	if n := r.SCEFeedback.TruePositives + r.SCEFeedback.Missed; n > 0 {
+		r.SCEEchoedPercent = 100 * float64(r.SCEFeedback.TruePositives) / float64(n)
+	}
+	if d.AccECN != nil {
+		r.AccECN = &AccECNResult{
+			AccECNData:        d.AccECN,
+			ObservedCEPackets: dr.CE,
+			ObservedECT0Bytes: dr.ECNBytes.ECT0,
+			ObservedCEBytes:   dr.ECNBytes.CE,
+			ObservedECT1Bytes: dr.ECNBytes.SCE,
+		}
+	}
+
+	return
+}
+
+type QUICFlowResult struct {
+	*QUICFlowData
+	Up   *QUICOneWayResult
+	Down *QUICOneWayResult
+}
+
+func NewQUICFlowResult(d *QUICFlowData) *QUICFlowResult {
+	return &QUICFlowResult{
+		QUICFlowData: d,
+		Up:           NewQUICOneWayResult(d.Up),
+		Down:         NewQUICOneWayResult(d.Down),
+	}
+}
+
+type QUICOneWayResult struct {
+	*QUICOneWayData
+	SCEPercent float64
+	CEPercent  float64
+}
+
+func NewQUICOneWayResult(d *QUICOneWayData) (r *QUICOneWayResult) {
+	r = &QUICOneWayResult{QUICOneWayData: d}
+
+	if r.Packets > 0 {
+		r.SCEPercent = 100 * float64(r.ECN.SCE) / float64(r.Packets)
+		r.CEPercent = 100 * float64(r.ECN.CE) / float64(r.Packets)
+	}
+
+	return
+}
+
+type EchoFlowResult struct {
+	*EchoFlowData
+	Unanswered  uint64
+	LostPercent float64
+}
+
+func NewEchoFlowResult(d *EchoFlowData) (r *EchoFlowResult) {
+	r = &EchoFlowResult{EchoFlowData: d}
+
+	r.Unanswered = uint64(len(d.SentTimes))
+	if r.Requests > 0 {
+		r.LostPercent = 100 * float64(r.Unanswered) / float64(r.Requests)
+	}
+
+	return
+}
+
+type IRTTFlowResult struct {
+	*IRTTFlowData
+	Unanswered          uint64
+	LostPercent         float64
+	UpstreamLostPercent float64 `json:",omitempty"`
+}
+
+func NewIRTTFlowResult(d *IRTTFlowData) (r *IRTTFlowResult) {
+	r = &IRTTFlowResult{IRTTFlowData: d}
+
+	r.Unanswered = uint64(len(d.SentTimes))
+	if n := r.RTT.N + r.Unanswered; n > 0 {
+		r.LostPercent = 100 * float64(r.Unanswered) / float64(n)
+	}
+	if r.ServerReceived > 0 && r.Requests > r.ServerReceived {
+		r.UpstreamLostPercent = 100 * float64(r.Requests-r.ServerReceived) /
+			float64(r.Requests)
+	}
 
 	return
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// MaxWindowScale is the maximum window scale shift count (RFC 7323).
+const MaxWindowScale = 14
+
+// windowScale returns the window scale option from a SYN, or -1 if there is
+// none.
+func windowScale(tcp *layers.TCP) int {
+	for _, opt := range tcp.Options {
+		if opt.OptionType == layers.TCPOptionKindWindowScale &&
+			opt.OptionLength == 3 {
+			if s := int(opt.OptionData[0]); s < MaxWindowScale {
+				return s
+			}
+			return MaxWindowScale
+		}
+	}
+	return -1
+}
+
+// ReceiveWindowAdvertised records the receive window advertised by a
+// non-SYN segment in this direction, where peerScale is the window scale
+// offered by the other direction. The window is scaled only if both SYNs
+// offered window scaling.
+func (o *TCPOneWayData) ReceiveWindowAdvertised(window uint16, peerScale int) {
+	rwnd := uint32(window)
+	if o.WindowScale >= 0 && peerScale >= 0 {
+		rwnd <<= uint(o.WindowScale)
+	}
+	o.ReceiveWindow.Push(float64(rwnd))
+	if rwnd == 0 && (!o.RwndKnown || o.Rwnd != 0) {
+		o.ZeroWindows++
+	}
+	o.Rwnd = rwnd
+	o.RwndKnown = true
+}
+
+// ReceiverLimit records whether this direction is receiver limited at time t,
+// given its bytes in flight and the receive window advertised by the other
+// direction. The sender is considered receiver limited when it couldn't send
+// another maximum sized segment without exceeding the receive window.
+func (o *TCPOneWayData) ReceiverLimit(flight, rwnd uint32, t time.Time) {
+	if o.RwndLimited {
+		o.ReceiverLimited += t.Sub(o.RwndLimitedTime)
+	}
+	o.RwndLimited = uint64(flight)+uint64(o.MaxSegLen) > uint64(rwnd)
+	o.RwndLimitedTime = t
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"text/tabwriter"
+)
+
+// EmitText writes the results as an aligned text table, with one row per TCP
+// flow and a footer with totals. Paired columns are given as Up/Down.
+func (r *Result) EmitText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	m := r.Meta.ECT1Mode
+	fmt.Fprintf(tw, "#\tFlow\tECN\tEnd\t%s%%\t%s%%\tCE\tRetrans%%\tGoodputMbit\tSeqRTTms\tTSValRTTms\n",
+		m.FieldName("SCE"), m.FieldName("ESCE"))
+	for _, f := range r.TCP {
+		fmt.Fprintf(tw, "%d\t%s > %s\t%s\t%s\t%.2f/%.2f\t%.2f/%.2f\t%d/%d\t%.2f/%.2f\t%.3f/%.3f\t%.3f\t%.3f\n",
+			f.Index,
+			hostPort(f.SrcIP, uint16(f.SrcPort)),
+			hostPort(f.DstIP, uint16(f.DstPort)),
+			ecnNegotiation(f.TCPFlowData),
+			f.End,
+			f.Up.SCEPercent, f.Down.SCEPercent,
+			f.Up.ESCEPercent, f.Down.ESCEPercent,
+			f.Up.CE, f.Down.CE,
+			f.Up.RetransmittedPercent, f.Down.RetransmittedPercent,
+			f.Up.GoodputMbit, f.Down.GoodputMbit,
+			f.MeanSeqRTTMillis,
+			f.MeanTSValRTTMillis)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(r.Groups) > 0 {
+		fmt.Fprintf(tw, "\nGroup\tFlows\t%s%%\t%s%%\tCE\tGoodputMbit\tSeqRTTms\tJain\n",
+			m.FieldName("SCE"), m.FieldName("ESCE"))
+		for _, g := range r.Groups {
+			fmt.Fprintf(tw, "%s\t%d\t%.2f/%.2f\t%.2f/%.2f\t%d/%d\t%.3f/%.3f\t%.3f/%.3f\t%.3f\n",
+				g.Name,
+				len(g.Flows),
+				g.Up.SCEPercent, g.Down.SCEPercent,
+				g.Up.ESCEPercent, g.Down.ESCEPercent,
+				g.Up.CE, g.Down.CE,
+				g.Up.GoodputMbit, g.Down.GoodputMbit,
+				durToMs(g.Up.SeqRTT.Mean()), durToMs(g.Down.SeqRTT.Mean()),
+				g.JainIndex)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "\n%d packets, %d bytes, %d TCP flows, captured in %.3fs (%.0f pps, %.2fMbit), parsed in %.3fs (%.0f pps)\n",
+		r.IP.Packets, r.IP.Bytes, len(r.TCP),
+		r.Meta.CaptureElapsed.Seconds(), r.Meta.CapturePacketsPerSecond, r.Meta.CaptureMbit,
+		r.Meta.ParseElapsed.Seconds(), r.Meta.ParsePacketsPerSecond); err != nil {
+		return err
+	}
+
+	var err error
+	if r.Fairness.Flows > 1 {
+		_, err = fmt.Fprintf(w, "Jain's fairness index %.3f across %d flows\n",
+			r.Fairness.JainIndex, r.Fairness.Flows)
+	}
+	if err == nil && len(r.Groups) > 1 {
+		_, err = fmt.Fprintf(w, "Jain's fairness index %.3f across %d groups\n",
+			r.Fairness.GroupJainIndex, len(r.Groups))
+	}
+	return err
+}
+
+// ecnNegotiation returns a short description of the ECN negotiation status.
+func ecnNegotiation(f *TCPFlowData) string {
+	switch {
+	case f.AccECNAccepted:
+		return "accecn"
+	case f.ECNInitiated && f.ECNAccepted:
+		return "yes"
+	case f.ECNInitiated:
+		return "refused"
+	}
+	return "no"
+}
+
+func hostPort(ip net.IP, port uint16) string {
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"time"
+)
+
+// DefaultMaxRTTAge is the default maximum age of the times kept for RTT
+// samples that have not yet been acked or echoed.
+const DefaultMaxRTTAge = 10 * time.Second
+
+// TimeQueue records the times that sequence numbers or TSVals were seen, for
+// RTT measurement, with bounded memory. Entries are evicted when they are
+// covered by a later ack or TSecr, or exceed a maximum age, and are counted in
+// Discarded if they were never used for an RTT sample.
+type TimeQueue struct {
+	Discarded uint64
+	times     map[uint32]time.Time
+	queue     []timeEntry
+	head      int
+}
+
+type timeEntry struct {
+	key  uint32
+	time time.Time
+}
+
+func NewTimeQueue() *TimeQueue {
+	return &TimeQueue{
+		times: make(map[uint32]time.Time),
+	}
+}
+
+// Put records the time t for key k, replacing any existing time.
+func (q *TimeQueue) Put(k uint32, t time.Time) {
+	q.times[k] = t
+	q.queue = append(q.queue, timeEntry{k, t})
+}
+
+// Take returns and removes the time for key k, if there is one.
+func (q *TimeQueue) Take(k uint32) (t time.Time, ok bool) {
+	if t, ok = q.times[k]; ok {
+		delete(q.times, k)
+	}
+	return
+}
+
+// Evict removes the oldest entries with keys before k, stopping at the first
+// entry at or after k. Keys are compared with wraparound, as sequence numbers.
+func (q *TimeQueue) Evict(k uint32) {
+	for q.head < len(q.queue) && !seqLE(k, q.queue[q.head].key) {
+		q.pop()
+	}
+	q.compact()
+}
+
+// Expire removes the entries older than maxAge at time t. A maxAge of zero
+// means entries don't expire.
+func (q *TimeQueue) Expire(t time.Time, maxAge time.Duration) {
+	if maxAge <= 0 {
+		return
+	}
+	for q.head < len(q.queue) && t.Sub(q.queue[q.head].time) > maxAge {
+		q.pop()
+	}
+	q.compact()
+}
+
+// Len returns the number of times recorded.
+func (q *TimeQueue) Len() int {
+	return len(q.times)
+}
+
+// pop removes the entry at the head of the queue, and its time if it hasn't
+// been taken or replaced.
+func (q *TimeQueue) pop() {
+	e := q.queue[q.head]
+	q.head++
+	if t, ok := q.times[e.key]; ok && t.Equal(e.time) {
+		delete(q.times, e.key)
+		q.Discarded++
+	}
+}
+
+// compact reclaims the space before the head of the queue, once it makes up
+// at least half of the queue.
+func (q *TimeQueue) compact() {
+	if q.head == 0 || q.head < len(q.queue)/2 {
+		return
+	}
+	n := copy(q.queue, q.queue[q.head:])
+	q.queue = q.queue[:n]
+	q.head = 0
+}
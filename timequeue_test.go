@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeQueueEvict(t *testing.T) {
+	tests := []struct {
+		name      string
+		keys      []uint32
+		take      []uint32
+		evict     uint32
+		remain    []uint32
+		discarded uint64
+	}{
+		{"before first", []uint32{100, 200, 300}, nil, 100,
+			[]uint32{100, 200, 300}, 0},
+		{"at bound", []uint32{100, 200, 300}, nil, 200,
+			[]uint32{200, 300}, 1},
+		{"between keys", []uint32{100, 200, 300}, nil, 250,
+			[]uint32{300}, 2},
+		{"all", []uint32{100, 200, 300}, nil, 301, nil, 3},
+		{"taken not discarded", []uint32{100, 200, 300}, []uint32{100}, 300,
+			[]uint32{300}, 1},
+		{"wraparound", []uint32{0xfffffff0, 0x10}, nil, 0x10,
+			[]uint32{0x10}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewTimeQueue()
+			t0 := time.Unix(0, 0)
+			for _, k := range tt.keys {
+				q.Put(k, t0)
+			}
+			for _, k := range tt.take {
+				q.Take(k)
+			}
+			q.Evict(tt.evict)
+			if q.Len() != len(tt.remain) {
+				t.Errorf("Len = %d, want %d", q.Len(), len(tt.remain))
+			}
+			for _, k := range tt.remain {
+				if _, ok := q.Take(k); !ok {
+					t.Errorf("key %d was evicted", k)
+				}
+			}
+			if q.Discarded != tt.discarded {
+				t.Errorf("Discarded = %d, want %d", q.Discarded, tt.discarded)
+			}
+		})
+	}
+}
+
+func TestTimeQueueExpire(t *testing.T) {
+	t0 := time.Unix(0, 0)
+	tests := []struct {
+		name   string
+		maxAge time.Duration
+		at     time.Duration
+		remain int
+	}{
+		{"none expired", time.Second, 1500 * time.Millisecond, 2},
+		{"at max age", time.Second, 2 * time.Second, 2},
+		{"past max age", time.Second, 2*time.Second + 1, 1},
+		{"no max age", 0, time.Hour, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewTimeQueue()
+			for i := 0; i < 3; i++ {
+				q.Put(uint32(i), t0.Add(time.Duration(i)*time.Second))
+			}
+			q.Expire(t0.Add(tt.at), tt.maxAge)
+			if q.Len() != tt.remain {
+				t.Errorf("Len = %d, want %d", q.Len(), tt.remain)
+			}
+		})
+	}
+}
+
+func TestTimeQueueReplaced(t *testing.T) {
+	q := NewTimeQueue()
+	t0 := time.Unix(0, 0)
+	q.Put(1, t0)
+	q.Put(1, t0.Add(time.Second))
+	q.Evict(1)
+	q.Expire(t0.Add(1500*time.Millisecond), time.Second)
+	if q.Len() != 1 || q.Discarded != 0 {
+		t.Fatalf("Len = %d, Discarded = %d, want 1, 0", q.Len(), q.Discarded)
+	}
+	if tm, _ := q.Take(1); !tm.Equal(t0.Add(time.Second)) {
+		t.Errorf("time = %s, want the replacement", tm)
+	}
+}
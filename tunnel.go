@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// IPHeaderInfo contains the fields recorded for each IP header in a packet.
+type IPHeaderInfo struct {
+	ECN    ECN
+	Length uint
+}
+
+// IPStack records the ECN field and total length of each IP header decoded
+// in a packet, outermost first, so that tunneled packets may be analyzed.
+// Reset must be called before decoding each packet.
+type IPStack struct {
+	Headers []IPHeaderInfo
+}
+
+// Reset clears the recorded headers.
+func (s *IPStack) Reset() {
+	s.Headers = s.Headers[:0]
+}
+
+// Tunneled returns true if more than one IP header was decoded.
+func (s *IPStack) Tunneled() bool {
+	return len(s.Headers) > 1
+}
+
+// Outer returns the outermost IP header.
+func (s *IPStack) Outer() IPHeaderInfo {
+	return s.Headers[0]
+}
+
+// Inner returns the innermost IP header.
+func (s *IPStack) Inner() IPHeaderInfo {
+	return s.Headers[len(s.Headers)-1]
+}
+
+// IPv4Layer decodes IPv4 headers and records them in an IPStack.
+type IPv4Layer struct {
+	layers.IPv4
+	Stack *IPStack
+}
+
+func (l *IPv4Layer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if err := l.IPv4.DecodeFromBytes(data, df); err != nil {
+		return err
+	}
+	l.Stack.Headers = append(l.Stack.Headers,
+		IPHeaderInfo{ECN(l.TOS & 0x03), uint(l.Length)})
+	return nil
+}
+
+// IPv6Layer decodes IPv6 headers and records them in an IPStack.
+type IPv6Layer struct {
+	layers.IPv6
+	Stack *IPStack
+}
+
+func (l *IPv6Layer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if err := l.IPv6.DecodeFromBytes(data, df); err != nil {
+		return err
+	}
+	l.Stack.Headers = append(l.Stack.Headers,
+		IPHeaderInfo{ECN(l.TrafficClass & 0x03), uint(l.Length) + 40})
+	return nil
+}
+
+// GeneveLayer decodes Geneve headers for gopacket's LayerTypeGeneve.
+type GeneveLayer struct {
+	layers.BaseLayer
+	Version  uint8
+	Protocol layers.EthernetType
+	VNI      uint32
+}
+
+func (g *GeneveLayer) LayerType() gopacket.LayerType {
+	return layers.LayerTypeGeneve
+}
+
+func (g *GeneveLayer) CanDecode() gopacket.LayerClass {
+	return layers.LayerTypeGeneve
+}
+
+func (g *GeneveLayer) NextLayerType() gopacket.LayerType {
+	return g.Protocol.LayerType()
+}
+
+func (g *GeneveLayer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 8 {
+		return errors.New("Geneve packet too small")
+	}
+	n := 8 + 4*int(data[0]&0x3f)
+	if len(data) < n {
+		return errors.New("Geneve options truncated")
+	}
+	g.Version = data[0] >> 6
+	g.Protocol = layers.EthernetType(binary.BigEndian.Uint16(data[2:4]))
+	g.VNI = binary.BigEndian.Uint32(data[4:8]) >> 8
+	g.BaseLayer = layers.BaseLayer{Contents: data[:n], Payload: data[n:]}
+	return nil
+}
+
+// MPLSLayer decodes MPLS label stack entries for gopacket's LayerTypeMPLS. The
+// payload after the bottom of the stack is assumed to be IPv4 or IPv6,
+// according to its first nibble.
+type MPLSLayer struct {
+	layers.BaseLayer
+	Label       uint32
+	StackBottom bool
+}
+
+func (m *MPLSLayer) LayerType() gopacket.LayerType {
+	return layers.LayerTypeMPLS
+}
+
+func (m *MPLSLayer) CanDecode() gopacket.LayerClass {
+	return layers.LayerTypeMPLS
+}
+
+func (m *MPLSLayer) NextLayerType() gopacket.LayerType {
+	if !m.StackBottom {
+		return layers.LayerTypeMPLS
+	}
+	if len(m.Payload) > 0 {
+		switch m.Payload[0] >> 4 {
+		case 4:
+			return layers.LayerTypeIPv4
+		case 6:
+			return layers.LayerTypeIPv6
+		}
+	}
+	return gopacket.LayerTypePayload
+}
+
+func (m *MPLSLayer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 4 {
+		return errors.New("MPLS packet too small")
+	}
+	e := binary.BigEndian.Uint32(data[:4])
+	m.Label = e >> 12
+	m.StackBottom = e&0x100 != 0
+	m.BaseLayer = layers.BaseLayer{Contents: data[:4], Payload: data[4:]}
+	return nil
+}
+
+// tunnelNames returns the names of the encapsulations in the decoded layers,
+// outermost first, with IP directly in IP given as IPIP.
+func tunnelNames(dec []gopacket.LayerType) (n []string) {
+	isIP := func(lt gopacket.LayerType) bool {
+		return lt == layers.LayerTypeIPv4 || lt == layers.LayerTypeIPv6
+	}
+	for i, lt := range dec {
+		var s string
+		switch lt {
+		case layers.LayerTypeGRE:
+			s = "GRE"
+		case layers.LayerTypeVXLAN:
+			s = "VXLAN"
+		case layers.LayerTypeGeneve:
+			s = "Geneve"
+		case layers.LayerTypeMPLS:
+			if i > 0 && dec[i-1] == layers.LayerTypeMPLS {
+				continue
+			}
+			s = "MPLS"
+		default:
+			if i > 0 && isIP(lt) && isIP(dec[i-1]) {
+				s = "IPIP"
+			}
+		}
+		if s != "" {
+			n = append(n, s)
+		}
+	}
+	return
+}
+
+// ECNMatrix counts packets by their outer and inner ECN codepoints, indexed
+// [outer][inner].
+type ECNMatrix [4][4]uint64
+
+// Add increments the count for the given outer and inner codepoints.
+func (m *ECNMatrix) Add(outer, inner ECN) {
+	m[outer&0x03][inner&0x03]++
+}
+
+// Counts returns the non-zero counts keyed by "outer/inner", e.g. "SCE/ECT0",
+// with codepoints named according to mode m. A '>' separator isn't used, as
+// encoding/json escapes it in keys.
+func (m *ECNMatrix) Counts(mode ECT1Mode) map[string]uint64 {
+	j := make(map[string]uint64)
+	for o := range m {
+		for i, n := range m[o] {
+			if n > 0 {
+				j[mode.Name(ECN(o))+"/"+mode.Name(ECN(i))] = n
+			}
+		}
+	}
+	return j
+}
+
+// MarshalJSON emits the non-zero counts as an object, with ECT(1) named SCE.
+func (m *ECNMatrix) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Counts(ModeSCE))
+}
+
+// ModeECNMatrix is an ECNMatrix that names ECT(1) according to Mode in JSON.
+type ModeECNMatrix struct {
+	*ECNMatrix
+	Mode ECT1Mode
+}
+
+func (m *ModeECNMatrix) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Counts(m.Mode))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Dot1QStack decodes 802.1Q headers, recording the VLAN IDs of each tag in a
+// stack (e.g. QinQ), outermost first. Reset must be called before decoding each
+// packet.
+type Dot1QStack struct {
+	layers.Dot1Q
+	IDs []uint16
+}
+
+func (d *Dot1QStack) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if err := d.Dot1Q.DecodeFromBytes(data, df); err != nil {
+		return err
+	}
+	d.IDs = append(d.IDs, d.VLANIdentifier)
+	return nil
+}
+
+// Reset clears the recorded VLAN IDs.
+func (d *Dot1QStack) Reset() {
+	d.IDs = d.IDs[:0]
+}
+
+// Key returns a value identifying the VLAN stack for use in flow keys, made
+// from the number of tags and the two outermost VLAN IDs.
+func (d *Dot1QStack) Key() (k uint32) {
+	for i, id := range d.IDs {
+		if i == 2 {
+			break
+		}
+		k |= uint32(id&0x0fff) << (12 * uint(1-i))
+	}
+	k |= uint32(len(d.IDs)) << 24
+	return
+}
+
+// vlanIDs returns a copy of ids, or nil if there are none.
+func vlanIDs(ids []uint16) []uint16 {
+	if len(ids) == 0 {
+		return nil
+	}
+	return append([]uint16(nil), ids...)
+}